@@ -1,9 +1,9 @@
 package inproc
 
 import (
-	"errors"
 	"fmt"
 	"net"
+	"path"
 	"strings"
 
 	syncutil "github.com/lthibault/util/sync"
@@ -29,27 +29,121 @@ func init() {
 		panic(err)
 	}
 
-	manet.RegisterFromNetAddr(toInprocMultiaddr)
-	manet.RegisterToNetAddr(toInprocNetAddr)
+	manet.RegisterFromNetAddr(toInprocMultiaddr, prefix)
+	manet.RegisterToNetAddr(toInprocNetAddr, prefix)
 }
 
 var c syncutil.Ctr
 
 // Resolve expands a multiaddress in the form "/inproc/~" to a random
-// free address.  It returns all other valid inproc addresses unchanged.
+// free address.  It returns all other valid inproc addresses unchanged,
+// and fails if the name is invalid.  See ValidateName.
 func Resolve(ma multiaddr.Multiaddr) (multiaddr.Multiaddr, error) {
 	s, err := ma.ValueForProtocol(P_INPROC)
 	if err != nil {
 		return nil, err
 	}
 
-	if s == "~" {
+	if err = ValidateName(s); err != nil {
+		return nil, err
+	}
+
+	if s == wildcard {
 		ma = multiaddr.StringCast(fmt.Sprintf("/inproc/%016x", c.Incr()))
 	}
 
 	return ma, nil
 }
 
+// IsPattern reports whether the multiaddress is a pattern, such as
+// "/inproc/service-*".  Patterns match names label by label, using the
+// syntax of path.Match, so a wildcard never matches a dot.  Hierarchies
+// are expressed with labels: "/inproc/*.region-a" matches
+// "/inproc/api.region-a", but not "/inproc/api.eu.region-a".  A
+// listener on a pattern accepts dials to every matching address that
+// is not bound more specifically.
+func IsPattern(ma multiaddr.Multiaddr) bool {
+	return isPattern(ma.String())
+}
+
+func isPattern(s string) bool { return strings.ContainsAny(s, "*?[\\") }
+
+func validatePattern(ma multiaddr.Multiaddr) error {
+	if _, err := path.Match(ma.String(), ""); err != nil {
+		return fmt.Errorf("invalid pattern %s: %w", ma, err)
+	}
+
+	return nil
+}
+
+// lookupAddr returns the value bound to the address in m, which is
+// keyed by multiaddress strings.  If the address is not bound exactly,
+// it falls back to the most specific matching pattern, i.e. the one
+// with the most literal characters.
+func lookupAddr[V any](m map[string]V, ma multiaddr.Multiaddr) (v V, ok bool) {
+	addr := ma.String()
+	if v, ok = m[addr]; ok {
+		return
+	}
+
+	best, bestKey := -1, ""
+	for key, val := range m {
+		if !isPattern(key) {
+			continue
+		}
+
+		if !matchLabels(key, addr) {
+			continue
+		}
+
+		// break ties lexically, so that lookups are deterministic
+		score := literals(key)
+		if score > best || (score == best && key < bestKey) {
+			v, ok, best, bestKey = val, true, score, key
+		}
+	}
+
+	return
+}
+
+// matchLabels reports whether addr matches pattern label by label, so
+// that wildcards do not match across the dots between labels.
+func matchLabels(pattern, addr string) bool {
+	ps, as := strings.Split(pattern, "."), strings.Split(addr, ".")
+	if len(ps) != len(as) {
+		return false
+	}
+
+	for i := range ps {
+		if match, _ := path.Match(ps[i], as[i]); !match {
+			return false
+		}
+	}
+
+	return true
+}
+
+// literals counts the characters in a pattern that match only
+// themselves.
+func literals(pattern string) (n int) {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '\\':
+			i++
+			n++
+		case '[':
+			for i < len(pattern) && pattern[i] != ']' {
+				i++
+			}
+		default:
+			n++
+		}
+	}
+
+	return
+}
+
 // ResolveString expands a multiaddress.  See 'Resolve'.
 func ResolveString(addr string) (multiaddr.Multiaddr, error) {
 	ma, err := multiaddr.NewMultiaddr(addr)
@@ -61,22 +155,92 @@ func ResolveString(addr string) (multiaddr.Multiaddr, error) {
 }
 
 func toInprocMultiaddr(na net.Addr) (multiaddr.Multiaddr, error) {
-	if a, ok := na.(addr); ok {
-		return a.Multiaddr, nil
+	var a Addr
+	switch na := na.(type) {
+	case Addr:
+		a = na
+	case *Addr:
+		a = *na
+	default:
+		// some other implementation of the inproc network
+		var err error
+		if a, err = ParseAddr(na.String()); err != nil {
+			return nil, err
+		}
 	}
 
-	return nil, errors.New("invalid address")
+	// the zero Addr has no name
+	if err := ValidateName(a.name); err != nil {
+		return nil, err
+	}
+
+	return a.Multiaddr(), nil
+}
+
+func toInprocNetAddr(ma multiaddr.Multiaddr) (net.Addr, error) {
+	name, err := ma.ValueForProtocol(P_INPROC)
+	if err != nil {
+		return nil, err
+	}
+
+	return Addr{name: name}, nil
+}
+
+// Addr is the net.Addr of an inproc multiaddr.  Addrs are comparable,
+// and convert to and from multiaddrs with manet.  The zero value is
+// not a valid address.
+type Addr struct{ name string }
+
+// NewAddr returns the address with the given name, such as "foo" for
+// "/inproc/foo".  See ValidateName.
+func NewAddr(name string) (Addr, error) {
+	if err := ValidateName(name); err != nil {
+		return Addr{}, err
+	}
+
+	return Addr{name: name}, nil
 }
 
-func toInprocNetAddr(ma multiaddr.Multiaddr) (net.Addr, error) { return addr{ma}, nil }
+// ParseAddr parses an address in any of the forms "inproc:foo",
+// "/inproc/foo", "/foo" or "foo".
+func ParseAddr(s string) (Addr, error) {
+	s = strings.TrimPrefix(s, prefix+":")
+	s = strings.TrimPrefix(s, "/"+prefix+"/")
+	return NewAddr(strings.TrimPrefix(s, "/"))
+}
+
+// Network returns "inproc".
+func (Addr) Network() string { return prefix }
 
-type addr struct{ multiaddr.Multiaddr }
+// String returns the address in the form "/foo".
+func (a Addr) String() string { return "/" + a.name }
 
-func (addr) Network() string  { return prefix }
-func (a addr) String() string { return strings.TrimPrefix(a.Multiaddr.String(), "/"+prefix) }
+// Name returns the name of the address, such as "foo".
+func (a Addr) Name() string { return a.name }
+
+// Multiaddr returns the address in the form "/inproc/foo".  It panics
+// if the address is not valid, such as the zero Addr.
+func (a Addr) Multiaddr() multiaddr.Multiaddr {
+	return multiaddr.StringCast("/" + prefix + "/" + a.name)
+}
 
+// transcoder enforces the grammar of inproc names.  See ValidateName.
 type transcoder struct{}
 
-func (transcoder) StringToBytes(s string) ([]byte, error) { return []byte(s), nil }
-func (transcoder) BytesToString(b []byte) (string, error) { return string(b), nil }
-func (transcoder) ValidateBytes([]byte) error             { return nil }
+func (transcoder) StringToBytes(s string) ([]byte, error) {
+	if err := ValidateName(s); err != nil {
+		return nil, err
+	}
+
+	return []byte(s), nil
+}
+
+func (transcoder) BytesToString(b []byte) (string, error) {
+	if err := ValidateName(string(b)); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (transcoder) ValidateBytes(b []byte) error { return ValidateName(string(b)) }
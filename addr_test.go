@@ -1,9 +1,11 @@
 package inproc
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,18 +16,65 @@ func TestMultiaddr(t *testing.T) {
 	t.Run("ToMultiaddr", func(t *testing.T) {
 		t.Parallel()
 
-		t.Skip("NOT IMPLEMENTED")
+		a, err := NewAddr("test")
+		require.NoError(t, err)
+
+		ma, err := manet.FromNetAddr(a)
+		require.NoError(t, err)
+		require.Equal(t, "/inproc/test", ma.String())
+
+		ma, err = manet.FromNetAddr(&a)
+		require.NoError(t, err)
+		require.Equal(t, "/inproc/test", ma.String())
+	})
+
+	t.Run("Zero", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := manet.FromNetAddr(Addr{})
+		require.ErrorIs(t, err, ErrInvalidName)
+
+		_, err = manet.FromNetAddr(&Addr{})
+		require.ErrorIs(t, err, ErrInvalidName)
 	})
 
 	t.Run("ToNetAddr", func(t *testing.T) {
 		t.Parallel()
 
-		a, err := toInprocNetAddr(multiaddr.StringCast("/inproc/test"))
+		a, err := manet.ToNetAddr(multiaddr.StringCast("/inproc/test"))
 		require.NoError(t, err)
-		require.IsType(t, addr{}, a)
+		require.IsType(t, Addr{}, a)
 		require.Equal(t, prefix, a.Network())
 		require.Equal(t, "/test", a.String())
 	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		t.Parallel()
+
+		ma := multiaddr.StringCast("/inproc/cluster.api")
+		na, err := manet.ToNetAddr(ma)
+		require.NoError(t, err)
+
+		ma2, err := manet.FromNetAddr(na)
+		require.NoError(t, err)
+		require.True(t, ma.Equal(ma2))
+	})
+}
+
+func TestParseAddr(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"inproc:foo", "/inproc/foo", "/foo", "foo"} {
+		a, err := ParseAddr(s)
+		require.NoError(t, err, s)
+		require.Equal(t, "foo", a.Name(), s)
+	}
+
+	_, err := ParseAddr("inproc:foo/bar")
+	require.ErrorIs(t, err, ErrInvalidName)
+
+	_, err = NewAddr("")
+	require.ErrorIs(t, err, ErrInvalidName)
 }
 
 func TestResolveString(t *testing.T) {
@@ -37,3 +86,74 @@ func TestResolveString(t *testing.T) {
 	require.NotEmpty(t, s)
 	require.NotEqual(t, "~", s, "should have expanded ~")
 }
+
+// FuzzTranscoder checks that inproc multiaddrs survive a round trip
+// through their string and binary forms.
+func FuzzTranscoder(f *testing.F) {
+	f.Add("test")
+	f.Add("service-*")
+	f.Add("")
+	f.Add("a/b")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		b, err := transcoder{}.StringToBytes(s)
+		if err != nil {
+			return
+		}
+
+		require.NoError(t, transcoder{}.ValidateBytes(b))
+		s2, err := transcoder{}.BytesToString(b)
+		require.NoError(t, err)
+		require.Equal(t, s, s2)
+
+		ma, err := multiaddr.NewMultiaddr("/inproc/" + s)
+		if err != nil {
+			return
+		}
+
+		ma2, err := multiaddr.NewMultiaddrBytes(ma.Bytes())
+		require.NoError(t, err, "should decode encoded multiaddr")
+		require.True(t, ma.Equal(ma2))
+
+		ma3, err := multiaddr.NewMultiaddr(ma.String())
+		require.NoError(t, err, "should parse formatted multiaddr %q", ma.String())
+		require.True(t, ma.Equal(ma3))
+	})
+}
+
+func TestValidateName(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name, reason string // empty reason if valid
+	}{
+		{name: "test"},
+		{name: "~"},
+		{name: "cluster.shard-1.api_v2"},
+		{name: "service-*"},
+		{name: "[a-c]?.\\*"},
+		{name: "", reason: "empty name"},
+		{name: "a..b", reason: "empty label"},
+		{name: ".a", reason: "empty label"},
+		{name: "a/b", reason: "invalid character '/'"},
+		{name: "a b", reason: "invalid character ' '"},
+		{name: "ü", reason: "invalid character"},
+		{name: "~a", reason: "'~' is reserved"},
+		{name: strings.Repeat("a", MaxLabelLen+1), reason: "longer than 63"},
+		{name: strings.Repeat("a.", MaxNameLen/2) + "ab", reason: "longer than 255"},
+	} {
+		err := ValidateName(tt.name)
+		if tt.reason == "" {
+			require.NoError(t, err, tt.name)
+			continue
+		}
+
+		require.ErrorIs(t, err, ErrInvalidName, tt.name)
+		require.ErrorContains(t, err, tt.reason, tt.name)
+	}
+
+	t.Run("Multiaddr", func(t *testing.T) {
+		_, err := multiaddr.NewMultiaddr("/inproc/a..b")
+		require.ErrorContains(t, err, "empty label", "should reject in transcoder")
+	})
+}
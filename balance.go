@@ -0,0 +1,120 @@
+package inproc
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Balancer chooses which of the transports bound to an address
+// receives a dial.  The transports are listed in the order in which
+// they were bound, and there are always at least two of them.
+//
+// Balancers are called while the Env is locked, so they must not call
+// its methods.
+type Balancer func(addr multiaddr.Multiaddr, ts []*Transport) *Transport
+
+// RoundRobin returns a balancer that cycles through the transports
+// bound to each address.
+func RoundRobin() Balancer {
+	var (
+		mu   sync.Mutex
+		next = make(map[string]int)
+	)
+
+	return func(addr multiaddr.Multiaddr, ts []*Transport) *Transport {
+		mu.Lock()
+		defer mu.Unlock()
+
+		key := addr.String()
+		i := next[key] % len(ts)
+		next[key] = i + 1
+
+		return ts[i]
+	}
+}
+
+// Random returns a balancer that chooses a transport uniformly at
+// random.  The choices are determined by the seed.
+func Random(seed int64) Balancer {
+	var (
+		mu sync.Mutex
+		r  = rand.New(rand.NewSource(seed))
+	)
+
+	return func(_ multiaddr.Multiaddr, ts []*Transport) *Transport {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return ts[r.Intn(len(ts))]
+	}
+}
+
+// LeastConnected chooses the transport with the fewest open conns
+// that it has accepted.  Ties go to the transport that was bound
+// first.  A conn is open until either end closes it.
+func LeastConnected(_ multiaddr.Multiaddr, ts []*Transport) *Transport {
+	best, fewest := ts[0], ts[0].numConns()
+	for _, t := range ts[1:] {
+		if n := t.numConns(); n < fewest {
+			best, fewest = t, n
+		}
+	}
+
+	return best
+}
+
+// tracked is a conn that was accepted by a transport.
+type tracked struct {
+	local, remote <-chan struct{} // closed when the respective end closes
+}
+
+func (c tracked) closed() bool {
+	return isClosedChan(c.local, c.remote)
+}
+
+// track records a conn that was accepted by the transport, so that
+// LeastConnected can balance dials.  Either end calls untrack when it
+// closes the conn.
+func (t *Transport) track(local, remote <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conns = append(t.conns, tracked{local: local, remote: remote})
+}
+
+// untrack forgets the accepted conns that have been closed.
+func (t *Transport) untrack() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+}
+
+// numConns returns the number of open conns that the transport has
+// accepted.
+func (t *Transport) numConns() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+	return len(t.conns)
+}
+
+// prune removes the closed conns from t.conns.  The caller must hold
+// t.mu.
+func (t *Transport) prune() {
+	open := t.conns[:0]
+	for _, c := range t.conns {
+		if !c.closed() {
+			open = append(open, c)
+		}
+	}
+
+	// clear the tail, so that the closed conns' channels can be freed
+	for i := len(open); i < len(t.conns); i++ {
+		t.conns[i] = tracked{}
+	}
+	t.conns = open
+}
@@ -0,0 +1,112 @@
+package inproc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalancer(t *testing.T) {
+	t.Parallel()
+
+	ma := multiaddr.StringCast("/inproc/replicated")
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		t.Parallel()
+
+		env := inproc.NewEnv(inproc.WithBalancer(inproc.RoundRobin()))
+		t0, t1 := &inproc.Transport{}, &inproc.Transport{}
+		require.True(t, env.Bind(ma, t0))
+		require.True(t, env.Bind(ma, t1))
+		require.False(t, env.Bind(ma, t0), "should not bind transport twice")
+
+		for _, want := range []*inproc.Transport{t0, t1, t0, t1} {
+			got, ok := env.Lookup(ma)
+			require.True(t, ok)
+			require.Same(t, want, got)
+		}
+
+		env.Release(ma, t0)
+		for i := 0; i < 2; i++ {
+			got, ok := env.Lookup(ma)
+			require.True(t, ok)
+			require.Same(t, t1, got, "should fail over to remaining transport")
+		}
+
+		env.Release(ma, t1)
+		_, ok := env.Lookup(ma)
+		require.False(t, ok, "should free address when last transport is released")
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		t.Parallel()
+
+		choices := func() (ts []int) {
+			env := inproc.NewEnv(inproc.WithBalancer(inproc.Random(42)))
+			bound := []*inproc.Transport{{}, {}, {}}
+			for _, tpt := range bound {
+				require.True(t, env.Bind(ma, tpt))
+			}
+
+			for i := 0; i < 16; i++ {
+				got, _ := env.Lookup(ma)
+				for j, tpt := range bound {
+					if got == tpt {
+						ts = append(ts, j)
+					}
+				}
+			}
+			return
+		}
+
+		require.Equal(t, choices(), choices(), "should be determined by seed")
+	})
+
+	t.Run("LeastConnected", func(t *testing.T) {
+		t.Parallel()
+
+		env := inproc.NewEnv(inproc.WithBalancer(inproc.LeastConnected))
+
+		l0, err := inproc.Listen(env, ma)
+		require.NoError(t, err)
+		defer l0.Close()
+
+		l1, err := inproc.Listen(env, ma)
+		require.NoError(t, err)
+		defer l1.Close()
+
+		accepted := make(chan net.Listener, 8)
+		for _, l := range []net.Listener{l0, l1} {
+			go func(l net.Listener) {
+				for {
+					if _, err := l.Accept(); err != nil {
+						return
+					}
+					accepted <- l
+				}
+			}(l)
+		}
+
+		dial := func() net.Conn {
+			conn, err := inproc.Dial(context.Background(), env, ma)
+			require.NoError(t, err)
+			return conn
+		}
+
+		c0 := dial()
+		require.Equal(t, l0, <-accepted)
+
+		c1 := dial()
+		defer c1.Close()
+		require.Equal(t, l1, <-accepted, "should avoid busy listener")
+
+		c0.Close()
+		c2 := dial()
+		defer c2.Close()
+		require.Equal(t, l0, <-accepted, "should prefer idle listener")
+	})
+}
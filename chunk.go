@@ -0,0 +1,54 @@
+package inproc
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Chunker splits writes into separate deliveries, so that a single
+// write may require several reads on the remote end, as it would on a
+// real network.  It is passed the number of bytes that remain to be
+// delivered, and returns the size of the next chunk.  Return values
+// outside the range [1, n] are clamped to it.
+type Chunker func(n int) int
+
+// FixedChunks returns a Chunker that delivers at most size bytes at a
+// time.  FixedChunks(1) causes every read to return a single byte.
+func FixedChunks(size int) Chunker {
+	return func(int) int { return size }
+}
+
+// RandomChunks returns a Chunker that delivers between 1 and max bytes
+// at a time, as chosen by a PRNG seeded with seed.  A max below 1 is
+// treated as 1.
+func RandomChunks(seed int64, max int) Chunker {
+	if max < 1 {
+		max = 1
+	}
+
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+
+	return func(int) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return 1 + r.Intn(max)
+	}
+}
+
+// next returns the next chunk of b to be delivered.
+func (c Chunker) next(b []byte) []byte {
+	if c == nil || len(b) == 0 {
+		return b
+	}
+
+	switch n := c(len(b)); {
+	case n < 1:
+		return b[:1]
+	case n < len(b):
+		return b[:n]
+	}
+
+	return b
+}
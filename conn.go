@@ -3,6 +3,9 @@ package inproc
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/mikelsr/go-libp2p/core/crypto"
 	"github.com/mikelsr/go-libp2p/core/network"
@@ -16,36 +19,183 @@ var _ transport.CapableConn = (*conn)(nil)
 type conn struct {
 	l      *listener
 	remote *conn
+	ma     multiaddr.Multiaddr // local address; differs from l's if l is bound to a pattern
 
-	cq     chan struct{}
-	accept chan *pipe
+	cq        chan struct{}
+	closeOnce sync.Once // protects closing cq
+	accepted  bool      // this is the listener's end
+
+	goAway     chan struct{} // closed when this end starts draining
+	goAwayOnce sync.Once
+
+	key    source // shared by both ends; see Scheduler
+	nextID uint64 // atomic; ID of the next stream opened at this end
+	active int64  // atomic; UnixNano of the last activity, by the local clock
+
+	mu      sync.Mutex
+	streams map[*pipe]struct{} // open at this end
+	inbound int                // streams that were opened by the remote end
+	removed chan struct{}      // closed and replaced when a stream is removed
+	backlog []*pipe            // streams waiting for AcceptStream
+	queued  chan struct{}      // closed and replaced when a stream is queued
 }
 
 func (remote *listener) newConnPair(local *listener) (*conn, *conn) {
 	lc, rc := newConn(local), newConn(remote)
 	lc.remote = rc
 	rc.remote = lc
+	lc.key = local.t.nextSource()
+	rc.key = lc.key
+
+	// As in yamux, streams opened by the dialer have odd IDs, and
+	// those opened by the listener have even IDs.
+	lc.nextID, rc.nextID = 1, 2
+	rc.accepted = true
 
 	return lc, rc
 }
 
 func newConn(l *listener) *conn {
 	return &conn{
-		l:      l,
-		cq:     make(chan struct{}),
-		accept: make(chan *pipe),
+		l:       l,
+		ma:      l.ma,
+		cq:      make(chan struct{}),
+		goAway:  make(chan struct{}),
+		streams: make(map[*pipe]struct{}),
+		removed: make(chan struct{}),
+		queued:  make(chan struct{}),
+	}
+}
+
+// open registers the conn with its transport, and starts enforcing
+// the transport's idle timeout.
+func (c *conn) open() {
+	c.l.t.mu.Lock()
+	c.l.t.cs[c] = struct{}{}
+	c.l.t.mu.Unlock()
+
+	atomic.StoreInt64(&c.active, c.l.t.clock.Now().UnixNano())
+	if c.l.t.idleTimeout > 0 || c.l.t.keepAlive > 0 {
+		go c.monitor()
+	}
+}
+
+func (c *conn) addStream(p *pipe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.streams[p] = struct{}{}
+	c.touch()
+}
+
+// admit registers a stream that was opened by the remote end, and
+// queues it to be accepted.  It fails if the transport's stream filter
+// refuses the stream, if the conn is closed or being drained, if the
+// transport's stream limit has been reached, or if the accept backlog
+// is full.
+func (c *conn) admit(p *pipe) error {
+	if f := c.l.t.filter; f != nil && !f(c) {
+		return ErrStreamRefused
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if isClosedChan(c.cq, c.remote.cq) {
+		return ErrConnClosed
+	}
+
+	if c.draining() {
+		return ErrGoAway
+	}
+
+	if max := c.l.t.maxStreams; max > 0 && c.inbound >= max {
+		return ErrStreamsExhausted
+	}
+
+	if max := c.l.t.backlog; max > 0 && len(c.backlog) >= max {
+		return ErrStreamsExhausted
+	}
+
+	c.backlog = append(c.backlog, p)
+	close(c.queued)
+	c.queued = make(chan struct{})
+
+	p.inbound = true
+	c.streams[p] = struct{}{}
+	c.inbound++
+	c.touch()
+	return nil
+}
+
+// removeStream is called when a stream is closed or reset at this end.
+func (c *conn) removeStream(p *pipe) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.streams[p]; !ok {
+		return
+	}
+
+	if p.inbound {
+		c.inbound--
 	}
+	delete(c.streams, p)
+
+	close(c.removed)
+	c.removed = make(chan struct{})
+	c.touch()
+}
+
+// link returns the current properties of the link that carries the
+// conn, and a channel that is closed when they change.
+func (c *conn) link() (Link, <-chan struct{}) {
+	return c.l.t.env.Links().lookup(c.localEndpoint(), c.remoteEndpoint())
+}
+
+func (c *conn) localEndpoint() Endpoint {
+	return Endpoint{Addr: c.LocalMultiaddr(), Peer: c.LocalPeer()}
+}
+
+func (c *conn) remoteEndpoint() Endpoint {
+	return Endpoint{Addr: c.RemoteMultiaddr(), Peer: c.RemotePeer()}
 }
 
 /* MuxedConn */
 
 // Close closes the stream muxer and the the underlying net.Conn.
+// Streams that are open at this end are reset.
 func (c *conn) Close() error {
-	select {
-	case <-c.cq:
-	default:
+	c.closeOnce.Do(func() {
 		close(c.cq)
-	}
+
+		c.mu.Lock()
+		streams := make([]*pipe, 0, len(c.streams))
+		for p := range c.streams {
+			streams = append(streams, p)
+		}
+		c.mu.Unlock()
+
+		for _, p := range streams {
+			p.Reset()
+		}
+
+		c.l.t.mu.Lock()
+		delete(c.l.t.cs, c)
+		c.l.t.mu.Unlock()
+
+		if c.accepted {
+			c.l.t.untrack()
+		} else {
+			c.remote.l.t.untrack()
+		}
+
+		c.l.t.env.Tracker().removeConn(c)
+	})
 	return nil
 }
 
@@ -60,23 +210,80 @@ func (c *conn) IsClosed() bool {
 
 // OpenStream creates a new stream.
 func (c *conn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
-	local, remote := newPipe()
+	faults := c.l.t.env.Faults()
+	if err := faults.openStream(c.LocalPeer(), c.RemotePeer()); err != nil {
+		return nil, err
+	}
 
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case c.remote.accept <- remote:
-		return local, nil
+	if c.draining() {
+		return nil, ErrGoAway
+	}
+
+	local, remote := newPipe(c.l.t.clock, c.remote.l.t.clock)
+	local.conn, remote.conn = c, c.remote
+	local.faults, remote.faults = faults, faults
+	local.chunk, remote.chunk = c.l.t.chunk, c.remote.l.t.chunk
+	local.linger, remote.linger = c.l.t.linger, c.remote.l.t.linger
+	remote.sniff = true // the remote end responds to protocol negotiation
+
+	id := atomic.AddUint64(&c.nextID, 2) - 2
+	local.id, remote.id = id, id
+	local.opened, remote.opened = c.l.t.clock.Now(), c.remote.l.t.clock.Now()
+	local.label = fmt.Sprintf("%s->%s#%d", c.LocalMultiaddr(), c.RemoteMultiaddr(), id)
+	remote.label = fmt.Sprintf("%s<-%s#%d", c.RemoteMultiaddr(), c.LocalMultiaddr(), id)
+	local.key = append(c.key[:len(c.key):len(c.key)], id, 0)
+	remote.key = append(c.key[:len(c.key):len(c.key)], id, 1)
+	local.sched = c.l.t.env.Scheduler()
+	remote.sched = local.sched
+
+	if !local.sched.await(EventOpen, local.key, local.label, ctx.Done(), c.cq, c.remote.cq) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrConnClosed
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+
+	// register the stream first, in case it is closed as soon as it
+	// is accepted
+	c.addStream(local)
+	c.l.t.env.Tracker().addStream(local, remote)
+
+	if err := c.remote.admit(remote); err != nil {
+		c.removeStream(local)
+		local.tracker.removeStream(local)
+		remote.tracker.removeStream(remote)
+		return nil, err
+	}
+
+	return local, nil
 }
 
 // AcceptStream accepts a stream opened by the other side.
 func (c *conn) AcceptStream() (network.MuxedStream, error) {
-	select {
-	case <-c.cq:
-		return nil, errors.New("closed")
-	case s := <-c.accept:
-		return s, nil
+	for {
+		c.mu.Lock()
+		var p *pipe
+		if len(c.backlog) > 0 {
+			p, c.backlog = c.backlog[0], c.backlog[1:]
+		}
+		queued := c.queued
+		c.mu.Unlock()
+
+		if p != nil {
+			return p, nil
+		}
+
+		select {
+		case <-c.cq:
+			return nil, errors.New("closed")
+		case <-c.remote.cq:
+			return nil, errors.New("closed by peer")
+		case <-queued:
+		}
 	}
 }
 
@@ -86,7 +293,7 @@ func (c *conn) Scope() network.ConnScope {
 
 /* ConnSecurity */
 
-func (c *conn) LocalPeer() peer.ID  { return c.l.t.h.ID() }
+func (c *conn) LocalPeer() peer.ID  { return c.l.t.id() }
 func (c *conn) RemotePeer() peer.ID { return c.remote.LocalPeer() }
 
 func (c *conn) LocalPrivateKey() crypto.PrivKey { return c.l.t.pk }
@@ -98,8 +305,8 @@ func (c *conn) ConnState() network.ConnectionState {
 
 /* ConnMultiaddrs */
 
-func (c *conn) LocalMultiaddr() multiaddr.Multiaddr  { return c.l.Multiaddr() }
-func (c *conn) RemoteMultiaddr() multiaddr.Multiaddr { return c.remote.l.Multiaddr() }
+func (c *conn) LocalMultiaddr() multiaddr.Multiaddr  { return c.ma }
+func (c *conn) RemoteMultiaddr() multiaddr.Multiaddr { return c.remote.ma }
 
 /* Transport */
 
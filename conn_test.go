@@ -0,0 +1,228 @@
+package inproc
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/go-libp2p/core/crypto"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	_, dc, _, lc := newConnTest(t, WithMaxStreams(2), WithAcceptBacklog(1))
+
+	s0, err := dc.OpenStream(ctx)
+	require.NoError(t, err, "should queue stream")
+	defer s0.Reset()
+
+	_, err = dc.OpenStream(ctx)
+	require.ErrorIs(t, err, ErrStreamsExhausted, "should fail when backlog is full")
+
+	a0, err := lc.AcceptStream()
+	require.NoError(t, err)
+
+	s1, err := dc.OpenStream(ctx)
+	require.NoError(t, err, "should queue stream once backlog drains")
+	defer s1.Reset()
+
+	a1, err := lc.AcceptStream()
+	require.NoError(t, err)
+	defer a1.Reset()
+
+	_, err = dc.OpenStream(ctx)
+	require.ErrorIs(t, err, ErrStreamsExhausted, "should fail at stream limit")
+
+	require.NoError(t, a0.Close())
+	s2, err := dc.OpenStream(ctx)
+	require.NoError(t, err, "should open stream once another is closed")
+	defer s2.Reset()
+
+	require.NoError(t, lc.Close())
+	_, err = dc.OpenStream(ctx)
+	require.ErrorIs(t, err, ErrConnClosed, "should fail fast when remote conn is closed")
+}
+
+func TestStreamFilter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var refuse int32
+	_, dc, _, lc := newConnTest(t, WithStreamFilter(func(c transport.CapableConn) bool {
+		return atomic.LoadInt32(&refuse) == 0
+	}))
+
+	s, err := dc.OpenStream(ctx)
+	require.NoError(t, err, "should accept stream")
+	defer s.Reset()
+
+	atomic.StoreInt32(&refuse, 1)
+	_, err = dc.OpenStream(ctx)
+	require.ErrorIs(t, err, ErrStreamRefused)
+
+	a, err := lc.AcceptStream()
+	require.NoError(t, err)
+	defer a.Reset()
+
+	// the refused stream was never queued
+	atomic.StoreInt32(&refuse, 0)
+	s, err = dc.OpenStream(ctx)
+	require.NoError(t, err)
+	defer s.Reset()
+	go s.Write([]byte("x"))
+
+	a, err = lc.AcceptStream()
+	require.NoError(t, err)
+	defer a.Reset()
+	b := make([]byte, 1)
+	_, err = io.ReadFull(a, b)
+	require.NoError(t, err)
+	require.Equal(t, "x", string(b), "should accept the next stream")
+}
+
+func TestUnlimitedBacklog(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	_, dc, _, lc := newConnTest(t, WithAcceptBacklog(0))
+
+	// a backlog of zero never blocks or refuses opens
+	n := DefaultAcceptBacklog + 1
+	for i := 0; i < n; i++ {
+		s, err := dc.OpenStream(ctx)
+		require.NoError(t, err, "should queue stream %d", i)
+		defer s.Reset()
+	}
+
+	for i := 0; i < n; i++ {
+		a, err := lc.AcceptStream()
+		require.NoError(t, err)
+		defer a.Reset()
+	}
+}
+
+func TestHostless(t *testing.T) {
+	t.Parallel()
+
+	_, dc, _, lc := newConnTest(t)
+
+	for _, c := range []*conn{dc, lc} {
+		id, err := peer.IDFromPrivateKey(c.LocalPrivateKey())
+		require.NoError(t, err)
+		require.Equal(t, id, c.LocalPeer(), "should derive peer ID from private key")
+	}
+	require.Equal(t, lc.LocalPeer(), dc.RemotePeer())
+	require.Equal(t, dc.LocalPeer(), lc.RemotePeer())
+}
+
+func TestCloseResetsStreams(t *testing.T) {
+	t.Parallel()
+
+	_, dc, _, lc := newConnTest(t)
+	s, a := openTestStream(t, dc, lc)
+
+	require.NoError(t, dc.Close())
+
+	// fail rather than hang if the streams are left open
+	deadline := time.Now().Add(time.Second)
+	for end, s := range map[string]network.MuxedStream{"local": s, "remote": a} {
+		require.NoError(t, s.SetReadDeadline(deadline))
+		_, err := s.Read(make([]byte, 1))
+		require.ErrorIs(t, err, network.ErrReset, "should reset %s end", end)
+	}
+}
+
+func TestAcceptStreamRemoteClose(t *testing.T) {
+	t.Parallel()
+
+	_, dc, _, lc := newConnTest(t)
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := lc.AcceptStream()
+		accepted <- err
+	}()
+
+	require.NoError(t, dc.Close())
+	select {
+	case err := <-accepted:
+		require.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("should stop accepting when the remote end closes the conn")
+	}
+}
+
+func TestUntrack(t *testing.T) {
+	t.Parallel()
+
+	_, dc, _, lc := newConnTest(t)
+	tracked := func() int {
+		lc.l.t.mu.Lock()
+		defer lc.l.t.mu.Unlock()
+
+		return len(lc.l.t.conns)
+	}
+
+	require.Equal(t, 1, tracked(), "should track accepted conn")
+
+	// without LeastConnected, nothing else prunes the accepted conns
+	require.NoError(t, dc.Close())
+	require.Zero(t, tracked(), "should forget conn closed by dialer")
+}
+
+// newConnTest returns a dialing transport, and both ends of a conn
+// that it dialed to a listener.  Options apply to both transports.
+func newConnTest(t *testing.T, opt ...Option) (dt *Transport, dc *conn, l transport.Listener, lc *conn) {
+	env := NewEnv()
+	opt = append([]Option{WithEnv(env)}, opt...)
+	lt := newTransport(nil, newTestKey(t), opt)
+	dt = newTransport(nil, newTestKey(t), opt)
+
+	l, err := lt.Listen(multiaddr.StringCast("/inproc/~"))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan transport.CapableConn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	c, err := dt.Dial(context.Background(), l.Multiaddr(), lt.id())
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	lc = (<-accepted).(*conn)
+	t.Cleanup(func() { lc.Close() })
+
+	return dt, c.(*conn), l, lc
+}
+
+func newTestKey(t *testing.T) crypto.PrivKey {
+	pk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	return pk
+}
+
+// openTestStream opens a stream from dc, and accepts it on lc.
+func openTestStream(t *testing.T, dc, lc *conn) (s, a network.MuxedStream) {
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+
+	a, err = lc.AcceptStream()
+	require.NoError(t, err)
+
+	return s, a
+}
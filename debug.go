@@ -0,0 +1,103 @@
+package inproc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+// DebugHandler serves the state of env, in the manner of
+// net/http/pprof.  By default, it responds with the JSON encoding of
+// a Snapshot.  With the query "?format=dot", it responds with a
+// Graphviz graph, in which each peer is a node and each conn is an
+// edge labeled with its streams and the bytes they have carried.
+// Every request takes a new snapshot, so counters are live.
+//
+//	http.Handle("/debug/inproc", inproc.DebugHandler(env))
+func DebugHandler(env Env) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := extend(env).Snapshot()
+
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(snap)
+
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			writeDOT(w, snap)
+
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		}
+	})
+}
+
+func writeDOT(w io.Writer, snap Snapshot) {
+	fmt.Fprintln(w, "graph inproc {")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+
+	// conns are identified by the addresses of their ends
+	node := make(map[string]int)
+	for i, p := range snap.Peers {
+		for _, a := range p.Addrs {
+			node[a] = i
+		}
+
+		label := append([]string{p.Peer.String()}, p.Addrs...)
+		if p.Peer == "" {
+			label = p.Addrs
+		}
+		fmt.Fprintf(w, "\tn%d [label=%q];\n", i, strings.Join(label, "\n"))
+	}
+
+	// each conn is reported by both of its ends
+	seen := make(map[[2]string]bool)
+	for i, p := range snap.Peers {
+		for _, c := range p.Conns {
+			key := [2]string{c.LocalAddr, c.RemoteAddr}
+			if seen[[2]string{c.RemoteAddr, c.LocalAddr}] {
+				continue
+			}
+			seen[key] = true
+
+			raddr, err := multiaddr.NewMultiaddr(c.RemoteAddr)
+			if err != nil {
+				continue
+			}
+
+			// the remote end may be bound to a pattern that matches
+			// the dialed address
+			j, ok := lookupAddr(node, raddr)
+			if !ok {
+				continue // the remote end is no longer bound
+			}
+
+			fmt.Fprintf(w, "\tn%d -- n%d [label=%q];\n", i, j, connLabel(c))
+		}
+	}
+
+	fmt.Fprintln(w, "}")
+}
+
+func connLabel(c ConnSnapshot) string {
+	protos := make([]string, 0, len(c.Streams))
+	for _, s := range c.Streams {
+		if s.Protocol != "" {
+			protos = append(protos, string(s.Protocol))
+		}
+	}
+
+	label := fmt.Sprintf("%d streams, %d bytes", len(c.Streams), c.Bytes)
+	if len(protos) > 0 {
+		label += "\n" + strings.Join(protos, "\n")
+	}
+
+	return label
+}
@@ -0,0 +1,94 @@
+package inproc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mikelsr/go-libp2p"
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p/core/host"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugHandler(t *testing.T) {
+	t.Parallel()
+
+	env := inproc.NewEnv()
+
+	h0, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h0.Close()
+
+	h1, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	require.NoError(t, h1.Connect(context.Background(), *host.InfoFromHost(h0)))
+
+	srv := httptest.NewServer(inproc.DebugHandler(env))
+	defer srv.Close()
+
+	get := func(query string) (*http.Response, string) {
+		res, err := http.Get(srv.URL + query)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(b)
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		res, body := get("")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var snap inproc.Snapshot
+		require.NoError(t, json.Unmarshal([]byte(body), &snap))
+		require.Len(t, snap.Peers, 2)
+	})
+
+	t.Run("DOT", func(t *testing.T) {
+		res, body := get("?format=dot")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Contains(t, body, "graph inproc {")
+		require.Contains(t, body, h0.ID().String())
+		require.Contains(t, body, "n0 -- n1", "should draw conn between hosts")
+		require.NotContains(t, body, "n1 -- n0", "should draw each conn once")
+	})
+
+	t.Run("Pattern", func(t *testing.T) {
+		env := inproc.NewEnv()
+
+		h2, err := libp2p.New(
+			libp2p.NoTransports,
+			libp2p.Transport(inproc.New(inproc.WithEnv(env))),
+			libp2p.ListenAddrStrings("/inproc/service-*"))
+		require.NoError(t, err)
+		defer h2.Close()
+
+		h3, err := newTestHost(env)
+		require.NoError(t, err)
+		defer h3.Close()
+
+		ma := multiaddr.StringCast("/inproc/service-1")
+		err = h3.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: []multiaddr.Multiaddr{ma}})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		inproc.DebugHandler(env).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?format=dot", nil))
+		require.Equal(t, 1, strings.Count(rec.Body.String(), " -- "),
+			"should draw conn to the host bound to a pattern")
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		res, _ := get("?format=yaml")
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
@@ -0,0 +1,145 @@
+package inproc
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrGoAway is returned when opening a stream on a conn that is being
+// drained, at either end.
+var ErrGoAway = errors.New("conn is going away")
+
+// Drainer is implemented by the conns and listeners of a Transport,
+// which can be shut down gracefully.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+var (
+	_ Drainer = (*conn)(nil)
+	_ Drainer = (*listener)(nil)
+	_ Drainer = (*Transport)(nil)
+)
+
+// Drain shuts the conn down gracefully.  Like yamux's GoAway, it stops
+// new streams from being opened at either end, and lets the open
+// streams finish.  The conn is closed when each stream has been closed
+// at both ends, or reset at either end.  If ctx expires first, Drain closes
+// the conn at once, resetting the streams that remain, and returns
+// ctx.Err().
+func (c *conn) Drain(ctx context.Context) error {
+	c.goAwayOnce.Do(func() { close(c.goAway) })
+
+	for {
+		n, removed := c.numStreams()
+		m, remoteRemoved := c.remote.numStreams()
+		if n+m == 0 {
+			return c.Close()
+		}
+
+		select {
+		case <-removed:
+		case <-remoteRemoved:
+		case <-c.cq:
+			return nil
+		case <-c.remote.cq:
+			return c.Close()
+		case <-ctx.Done():
+			c.Close()
+			return ctx.Err()
+		}
+	}
+}
+
+// draining returns true if either end of the conn is being drained.
+func (c *conn) draining() bool {
+	return isClosedChan(c.goAway, c.remote.goAway)
+}
+
+// numStreams returns the number of streams that are open at this end
+// of the conn, and a channel that is closed when one of them is closed
+// or reset.  Streams that the remote end reset are finished, even if
+// they have not been closed at this end.  They are signalled by the
+// remote conn's channel.
+func (c *conn) numStreams() (n int, removed <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for p := range c.streams {
+		if !isClosedChan(p.remoteReset) {
+			n++
+		}
+	}
+
+	return n, c.removed
+}
+
+// Drain stops accepting conns, as Close does, and then drains the
+// conns that the listener accepted.  See Transport.Drain.
+func (l listener) Drain(ctx context.Context) error {
+	l.Close()
+
+	return l.t.drain(ctx, func(c *conn) bool {
+		return c.accepted && c.l.cq == l.cq
+	})
+}
+
+// Drain shuts the transport down gracefully, for testing rolling
+// restarts.  It closes the transport's listeners, so that dials to
+// their addresses are refused, and then drains each of its conns.  It
+// returns when every conn is closed.  If ctx expires first, the
+// remaining conns are closed at once, and Drain returns ctx.Err().
+func (t *Transport) Drain(ctx context.Context) error {
+	t.mu.RLock()
+	ls := make([]*listener, 0, len(t.ls))
+	for _, l := range t.ls {
+		ls = append(ls, l)
+	}
+	nls := make([]*netListener, 0, len(t.nls))
+	for _, l := range t.nls {
+		nls = append(nls, l)
+	}
+	t.mu.RUnlock()
+
+	for _, l := range ls {
+		l.Close()
+	}
+	for _, l := range nls {
+		l.Close()
+	}
+
+	return t.drain(ctx, func(*conn) bool { return true })
+}
+
+// drain drains the transport's open conns that match filter,
+// concurrently, and returns the first error.
+func (t *Transport) drain(ctx context.Context, filter func(*conn) bool) error {
+	t.mu.RLock()
+	var cs []*conn
+	for c := range t.cs {
+		if filter(c) {
+			cs = append(cs, c)
+		}
+	}
+	t.mu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		err  error
+	)
+	for _, c := range cs {
+		wg.Add(1)
+		go func(c *conn) {
+			defer wg.Done()
+
+			if e := c.Drain(ctx); e != nil {
+				once.Do(func() { err = e })
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return err
+}
@@ -0,0 +1,120 @@
+package inproc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("Conn", func(t *testing.T) {
+		t.Parallel()
+
+		_, dc, _, lc := newConnTest(t)
+		s, a := openTestStream(t, dc, lc)
+
+		drained := make(chan error, 1)
+		go func() { drained <- lc.Drain(ctx) }()
+		require.Eventually(t, lc.draining, time.Second, time.Millisecond)
+
+		_, err := dc.OpenStream(ctx)
+		require.ErrorIs(t, err, ErrGoAway, "remote end should not open streams")
+		_, err = lc.OpenStream(ctx)
+		require.ErrorIs(t, err, ErrGoAway, "draining end should not open streams")
+
+		go func() {
+			io.WriteString(s, "hello")
+			s.Close()
+		}()
+		b, err := io.ReadAll(a)
+		require.NoError(t, err, "open streams should finish")
+		require.Equal(t, "hello", string(b))
+
+		select {
+		case <-drained:
+			t.Fatal("should not close while a stream is open at either end")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		require.NoError(t, a.Close())
+		require.NoError(t, <-drained)
+		require.True(t, lc.IsClosed())
+	})
+
+	t.Run("RemoteReset", func(t *testing.T) {
+		t.Parallel()
+
+		_, dc, _, lc := newConnTest(t)
+		s, _ := openTestStream(t, dc, lc)
+
+		drained := make(chan error, 1)
+		go func() { drained <- lc.Drain(ctx) }()
+		require.Eventually(t, lc.draining, time.Second, time.Millisecond)
+
+		// the accepted end is never closed, but the stream is finished
+		require.NoError(t, s.Reset())
+		select {
+		case err := <-drained:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("should not wait for streams that the remote end reset")
+		}
+		require.True(t, lc.IsClosed())
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		t.Parallel()
+
+		_, dc, _, lc := newConnTest(t)
+		_, a := openTestStream(t, dc, lc)
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, lc.Drain(ctx), context.DeadlineExceeded)
+		require.True(t, lc.IsClosed())
+
+		_, err := a.Read(make([]byte, 1))
+		require.ErrorIs(t, err, network.ErrReset, "should reset remaining streams")
+	})
+
+	t.Run("Listener", func(t *testing.T) {
+		t.Parallel()
+
+		dt, dc, l, lc := newConnTest(t)
+
+		require.NoError(t, l.(Drainer).Drain(ctx))
+		require.True(t, lc.IsClosed(), "should drain accepted conns")
+		require.False(t, dc.IsClosed(), "should not close the dialer's end")
+
+		_, err := dt.Dial(ctx, l.Multiaddr(), lc.LocalPeer())
+		require.ErrorIs(t, err, ErrRefused, "should stop accepting conns")
+	})
+
+	t.Run("Transport", func(t *testing.T) {
+		t.Parallel()
+
+		dt, dc, l, lc := newConnTest(t)
+		s, a := openTestStream(t, dc, lc)
+
+		drained := make(chan error, 1)
+		go func() { drained <- lc.l.t.Drain(ctx) }()
+		require.Eventually(t, lc.draining, time.Second, time.Millisecond)
+
+		_, err := dt.Dial(ctx, l.Multiaddr(), lc.LocalPeer())
+		require.ErrorIs(t, err, ErrRefused, "should close listeners")
+
+		require.NoError(t, s.Close())
+		require.NoError(t, a.Close())
+		require.NoError(t, <-drained)
+		require.True(t, lc.IsClosed())
+	})
+}
@@ -3,7 +3,9 @@ package inproc
 import (
 	"bytes"
 	"sync"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -22,36 +24,227 @@ type Env interface {
 	List() AddrSlice
 }
 
+// ExtendedEnv is an Env that supports shared bindings, leases,
+// snapshots and network simulation.  NewEnv returns an ExtendedEnv.
+// Transports may be given any Env; they treat one that is not an
+// ExtendedEnv as if it were created by NewEnv without options.
+//
+// The caller is responsible for explicit locking during calls to
+// 'Release' and 'Renew'.
+type ExtendedEnv interface {
+	Env
+
+	// Release removes the transport's binding to the address.  Other
+	// transports that are bound to the same address are unaffected.
+	Release(multiaddr.Multiaddr, *Transport)
+
+	// TTL returns the duration of the Env's leases, or zero if
+	// bindings are permanent.
+	TTL() time.Duration
+
+	// Renew extends the transport's lease on the address, and reports
+	// whether the transport is still bound to it.
+	Renew(multiaddr.Multiaddr, *Transport) bool
+
+	// Dangling reports the bindings whose leases expired before they
+	// were released.  Like 'List', it must not be called while holding
+	// a lock on Env.
+	Dangling() []Binding
+
+	// Snapshot describes the bindings, conns, streams and links in
+	// the Env.  Like 'List', it must not be called while holding a
+	// lock on Env.
+	Snapshot() Snapshot
+
+	// Tracker returns the tracker of open conns and streams in the
+	// Env, or nil if they are not tracked.
+	Tracker() *Tracker
+
+	// Scheduler returns the scheduler that releases delivery events
+	// in the Env, or nil if events are delivered immediately.
+	Scheduler() *Scheduler
+
+	// Faults returns the fault injector for the Env, or nil if no
+	// faults are injected.
+	Faults() *FaultInjector
+
+	// Links returns the table of link properties for the Env.
+	Links() *LinkTable
+}
+
+// extend returns env as an ExtendedEnv, wrapping it if necessary.
+func extend(env Env) ExtendedEnv {
+	if ext, ok := env.(ExtendedEnv); ok {
+		return ext
+	}
+
+	return basicEnv{Env: env, links: newLinkTable()}
+}
+
+// basicEnv extends an Env that only supports the methods of Env.
+// Its bindings are permanent and exclusive, and its network is ideal.
+type basicEnv struct {
+	Env
+	links *LinkTable
+}
+
+func (env basicEnv) Release(ma multiaddr.Multiaddr, t *Transport) {
+	if bound, ok := env.Lookup(ma); ok && bound == t {
+		env.Free(ma)
+	}
+}
+
+func (env basicEnv) TTL() time.Duration { return 0 }
+
+func (env basicEnv) Renew(ma multiaddr.Multiaddr, t *Transport) bool {
+	bound, ok := env.Lookup(ma)
+	return ok && bound == t
+}
+
+func (env basicEnv) Dangling() []Binding { return nil }
+
+func (env basicEnv) Snapshot() Snapshot {
+	as := env.List()
+
+	env.Lock()
+	addrs := make(map[*Transport][]string)
+	for _, ma := range as {
+		if t, ok := env.Lookup(ma); ok {
+			addrs[t] = append(addrs[t], ma.String())
+		}
+	}
+	env.Unlock()
+
+	return snapshot(addrs, env.links)
+}
+
+func (env basicEnv) Tracker() *Tracker      { return nil }
+func (env basicEnv) Scheduler() *Scheduler  { return nil }
+func (env basicEnv) Faults() *FaultInjector { return nil }
+func (env basicEnv) Links() *LinkTable      { return env.links }
+
+// EnvOption configures the default Env implementation.
+type EnvOption func(*mapEnv)
+
+// WithScheduler places the Env in deterministic simulation mode.
+// Every conn accept, stream open, data chunk, close and reset is
+// queued until it is released by the scheduler.
+func WithScheduler(s *Scheduler) EnvOption {
+	return func(env *mapEnv) {
+		env.sched = s
+	}
+}
+
+// WithFaults injects failures into the Env's conns and streams,
+// according to the rules in f.
+func WithFaults(f *FaultInjector) EnvOption {
+	return func(env *mapEnv) {
+		env.faults = f
+	}
+}
+
+// WithBalancer allows several transports to bind the same address.
+// Each lookup of the address returns the transport chosen by b.
+// Without a balancer, binding an address that is in use fails.
+func WithBalancer(b Balancer) EnvOption {
+	return func(env *mapEnv) {
+		env.balance = b
+	}
+}
+
+// WithTracker records the conns and streams that are opened in the
+// Env, so that tests can report those that are not closed.
+func WithTracker(tr *Tracker) EnvOption {
+	return func(env *mapEnv) {
+		env.tracker = tr
+	}
+}
+
 // NewEnv returns a new instance of the default Env implementation.
-func NewEnv() Env { return &mapEnv{bs: make(map[string]*record)} }
+func NewEnv(opt ...EnvOption) ExtendedEnv {
+	env := &mapEnv{
+		bs:    make(map[string]*record),
+		links: newLinkTable(),
+	}
+	for _, option := range opt {
+		option(env)
+	}
+
+	return env
+}
 
 type mapEnv struct {
 	sync.RWMutex
 	bs map[string]*record
+
+	sched   *Scheduler
+	faults  *FaultInjector
+	links   *LinkTable
+	balance Balancer
+	tracker *Tracker
+
+	ttl      time.Duration
+	clock    clock.Clock
+	dangling []Binding
 }
 
 func (env *mapEnv) Bind(ma multiaddr.Multiaddr, t *Transport) bool {
-	if _, ok := env.bs[ma.String()]; ok {
+	env.expire()
+
+	rec, ok := env.bs[ma.String()]
+	if !ok {
+		rec = &record{Addr: ma}
+		env.bs[ma.String()] = rec
+	} else if env.balance == nil || rec.bound(t) {
 		return false
 	}
 
-	env.bs[ma.String()] = &record{Addr: ma, T: t}
+	rec.Ts = append(rec.Ts, t)
+	env.lease(rec, t)
 	return true
 }
 
 func (env *mapEnv) Lookup(ma multiaddr.Multiaddr) (*Transport, bool) {
-	if rec, ok := env.bs[ma.String()]; ok {
-		return rec.T, ok
+	env.expire()
+
+	rec, ok := lookupAddr(env.bs, ma)
+	if !ok {
+		return nil, false
+	}
+
+	if len(rec.Ts) == 1 {
+		return rec.Ts[0], true
 	}
 
-	return nil, false
+	return env.balance(rec.Addr, rec.Ts), true
 }
 
 func (env *mapEnv) Free(ma multiaddr.Multiaddr) { delete(env.bs, ma.String()) }
 
+func (env *mapEnv) Release(ma multiaddr.Multiaddr, t *Transport) {
+	rec, ok := env.bs[ma.String()]
+	if !ok {
+		return
+	}
+
+	for i, bound := range rec.Ts {
+		if bound == t {
+			rec.Ts = append(rec.Ts[:i], rec.Ts[i+1:]...)
+			delete(rec.leases, t)
+			break
+		}
+	}
+
+	if len(rec.Ts) == 0 {
+		env.Free(ma)
+	}
+}
+
 func (env *mapEnv) List() AddrSlice {
-	env.RLock()
-	defer env.RUnlock()
+	env.Lock()
+	defer env.Unlock()
+
+	env.expire()
 
 	addrs := make(AddrSlice, 0, len(env.bs))
 	for _, rec := range env.bs {
@@ -61,9 +254,26 @@ func (env *mapEnv) List() AddrSlice {
 	return addrs
 }
 
+func (env *mapEnv) Scheduler() *Scheduler  { return env.sched }
+func (env *mapEnv) Faults() *FaultInjector { return env.faults }
+func (env *mapEnv) Links() *LinkTable      { return env.links }
+func (env *mapEnv) Tracker() *Tracker      { return env.tracker }
+
 type record struct {
 	Addr multiaddr.Multiaddr
-	T    *Transport
+	Ts   []*Transport // in the order in which they were bound
+
+	leases map[*Transport]*lease // nil if bindings are permanent
+}
+
+func (rec *record) bound(t *Transport) bool {
+	for _, bound := range rec.Ts {
+		if bound == t {
+			return true
+		}
+	}
+
+	return false
 }
 
 type AddrSlice []multiaddr.Multiaddr
@@ -37,3 +37,39 @@ func TestBind(t *testing.T) {
 		assert.Nil(t, tpt)
 	})
 }
+
+func TestPattern(t *testing.T) {
+	t.Parallel()
+
+	env := inproc.NewEnv()
+	any, prefixed, exact := &inproc.Transport{}, &inproc.Transport{}, &inproc.Transport{}
+	require.True(t, env.Bind(multiaddr.StringCast("/inproc/*"), any))
+	require.True(t, env.Bind(multiaddr.StringCast("/inproc/service-*"), prefixed))
+	require.True(t, env.Bind(multiaddr.StringCast("/inproc/service-1"), exact))
+
+	for _, tt := range []struct {
+		addr string
+		want *inproc.Transport
+	}{
+		{"/inproc/service-1", exact},
+		{"/inproc/service-2", prefixed},
+		{"/inproc/other", any},
+	} {
+		tpt, ok := env.Lookup(multiaddr.StringCast(tt.addr))
+		require.True(t, ok, tt.addr)
+		require.Same(t, tt.want, tpt, "should match most specific binding for %s", tt.addr)
+	}
+
+	env.Free(multiaddr.StringCast("/inproc/*"))
+	_, ok := env.Lookup(multiaddr.StringCast("/inproc/other"))
+	require.False(t, ok)
+
+	// wildcards do not match across labels
+	region := &inproc.Transport{}
+	require.True(t, env.Bind(multiaddr.StringCast("/inproc/*.region-a"), region))
+	tpt, ok := env.Lookup(multiaddr.StringCast("/inproc/api.region-a"))
+	require.True(t, ok)
+	require.Same(t, region, tpt)
+	_, ok = env.Lookup(multiaddr.StringCast("/inproc/api.eu.region-a"))
+	require.False(t, ok, "should not match more labels than the pattern has")
+}
@@ -0,0 +1,261 @@
+package inproc
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/protocol"
+)
+
+// ErrInjected is returned by operations that fail because of a Rule,
+// unless the rule specifies its own error.
+var ErrInjected = errors.New("injected fault")
+
+// Fault is a failure that a FaultInjector can inject.
+type Fault uint8
+
+const (
+	// FaultDial makes Transport.Dial fail.
+	FaultDial Fault = iota
+	// FaultAccept makes a listener drop an incoming conn.  The dialer
+	// receives a conn that is already closed.
+	FaultAccept
+	// FaultOpenStream makes OpenStream fail.
+	FaultOpenStream
+	// FaultResetStream resets a stream during a write.
+	FaultResetStream
+	// FaultCloseConn closes a conn during a write to one of its
+	// streams.
+	FaultCloseConn
+	// FaultTransform rewrites the data written to a stream, using the
+	// rule's Transformer.
+	FaultTransform
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultDial:
+		return "dial"
+	case FaultAccept:
+		return "accept"
+	case FaultOpenStream:
+		return "open-stream"
+	case FaultResetStream:
+		return "reset-stream"
+	case FaultCloseConn:
+		return "close-conn"
+	case FaultTransform:
+		return "transform"
+	}
+
+	return fmt.Sprintf("Fault(%d)", f)
+}
+
+// Rule describes when a FaultInjector injects a fault.  Zero-valued
+// filters match anything.
+type Rule struct {
+	Fault Fault
+
+	// Local and Remote restrict the rule to events between the given
+	// peers, as seen from the side on which the event occurs:  the
+	// dialer for FaultDial, the listener for FaultAccept, the opener
+	// for FaultOpenStream and the writer for stream faults.
+	Local, Remote peer.ID
+
+	// Protocol restricts the rule to streams that negotiated the
+	// given protocol.  The protocol is known once the responder has
+	// accepted it, so data sent optimistically by the initiator
+	// beforehand is not matched.  Rules that set Protocol never match
+	// FaultDial, FaultAccept or FaultOpenStream.
+	Protocol protocol.ID
+
+	// AfterBytes triggers FaultResetStream and FaultCloseConn once
+	// the stream has carried AfterBytes in total, in either direction.
+	// The write that crosses the threshold is truncated to it.
+	AfterBytes int64
+
+	// Probability triggers the fault on each matching event with the
+	// given probability.  It is ignored if AfterBytes is set.  If both
+	// are zero, the fault is injected on every matching event.
+	Probability float64
+
+	// Transform rewrites each matching write for FaultTransform.
+	Transform Transformer
+
+	// Err is returned by operations that fail because of the rule,
+	// including the write that triggers a stream fault.  The remote
+	// end of the stream sees a reset.  Defaults to ErrInjected.
+	Err error
+}
+
+// FaultInjector injects failures into the conns and streams of an
+// Env, according to a set of rules that may change at runtime.  All
+// random choices are drawn from a PRNG with a fixed seed.
+//
+// A nil *FaultInjector never injects faults.
+type FaultInjector struct {
+	mu    sync.Mutex
+	rand  *rand.Rand
+	rules []*Rule
+}
+
+// NewFaultInjector returns a fault injector without any rules, whose
+// random choices are determined by seed.
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Inject adds a rule.  The returned function removes it.
+func (f *FaultInjector) Inject(r Rule) (remove func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rule := &r
+	f.rules = append(f.rules, rule)
+
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		for i, r := range f.rules {
+			if r == rule {
+				f.rules = append(f.rules[:i], f.rules[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Clear removes all rules.
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rules = nil
+}
+
+func (f *FaultInjector) dial(local, remote peer.ID) error {
+	return f.fail(FaultDial, local, remote)
+}
+
+func (f *FaultInjector) accept(local, remote peer.ID) bool {
+	return f.fail(FaultAccept, local, remote) != nil
+}
+
+func (f *FaultInjector) openStream(local, remote peer.ID) error {
+	return f.fail(FaultOpenStream, local, remote)
+}
+
+// fail returns a non-nil error if a rule for fault matches an event
+// between local and remote.
+func (f *FaultInjector) fail(fault Fault, local, remote peer.ID) error {
+	if f == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, r := range f.rules {
+		if r.Fault == fault && r.Protocol == "" && r.matchPeers(local, remote) && f.roll(r) {
+			return r.err()
+		}
+	}
+
+	return nil
+}
+
+// write is called before n bytes are written to a stream that has
+// already carried total bytes.  If a stream fault must be injected,
+// it returns the number of bytes that may be delivered beforehand,
+// and the rule.  Otherwise, limit is negative.
+func (f *FaultInjector) write(local, remote peer.ID, proto protocol.ID, total int64, n int) (limit int, rule *Rule) {
+	if f == nil {
+		return -1, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	limit = -1
+	for _, r := range f.rules {
+		if r.Fault != FaultResetStream && r.Fault != FaultCloseConn {
+			continue
+		}
+
+		if !r.matchPeers(local, remote) || (r.Protocol != "" && r.Protocol != proto) {
+			continue
+		}
+
+		at := -1
+		switch {
+		case r.AfterBytes > 0:
+			if total+int64(n) >= r.AfterBytes {
+				at = int(r.AfterBytes - total)
+				if at < 0 {
+					at = 0
+				}
+			}
+
+		case f.roll(r):
+			at = 0
+		}
+
+		if at >= 0 && (limit < 0 || at < limit) {
+			limit, rule = at, r
+		}
+	}
+
+	return
+}
+
+// transform passes a chunk written at offset through the transformers
+// of matching rules, in the order in which the rules were added.
+func (f *FaultInjector) transform(local, remote peer.ID, proto protocol.ID, offset int64, b []byte) (out []byte, eof bool) {
+	if f == nil {
+		return b, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out = b
+	for _, r := range f.rules {
+		if r.Fault != FaultTransform || r.Transform == nil {
+			continue
+		}
+
+		if !r.matchPeers(local, remote) || (r.Protocol != "" && r.Protocol != proto) {
+			continue
+		}
+
+		if f.roll(r) {
+			if out, eof = r.Transform(offset, out, f.rand); eof {
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// roll reports whether a probabilistic rule fires.  The caller must
+// hold f.mu.
+func (f *FaultInjector) roll(r *Rule) bool {
+	return r.Probability == 0 || f.rand.Float64() < r.Probability
+}
+
+func (r *Rule) matchPeers(local, remote peer.ID) bool {
+	return (r.Local == "" || r.Local == local) && (r.Remote == "" || r.Remote == remote)
+}
+
+func (r *Rule) err() error {
+	if r.Err != nil {
+		return r.Err
+	}
+
+	return ErrInjected
+}
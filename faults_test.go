@@ -0,0 +1,137 @@
+package inproc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjector(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ResetAfterBytes", func(t *testing.T) {
+		t.Parallel()
+
+		f := NewFaultInjector(0)
+		f.Inject(Rule{Fault: FaultResetStream, AfterBytes: 3})
+
+		local, remote := newPipe(clock.New(), clock.New())
+		local.faults, remote.faults = f, f
+
+		cherr := make(chan error, 1)
+		go func() {
+			n, err := local.Write([]byte("hello"))
+			assert.Equal(t, 3, n, "should deliver bytes preceding the fault")
+			cherr <- err
+		}()
+
+		b, err := io.ReadAll(remote)
+		require.ErrorIs(t, err, network.ErrReset)
+		require.Equal(t, "hel", string(b))
+		require.ErrorIs(t, <-cherr, ErrInjected, "writer should see the rule's error")
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		t.Parallel()
+
+		errTest := errors.New("test")
+
+		f := NewFaultInjector(0)
+		f.Inject(Rule{Fault: FaultResetStream, Err: errTest})
+
+		local, remote := newPipe(clock.New(), clock.New())
+		local.faults, remote.faults = f, f
+
+		_, err := local.Write([]byte("hello"))
+		require.ErrorIs(t, err, errTest)
+
+		_, err = remote.Read(make([]byte, 1))
+		require.ErrorIs(t, err, network.ErrReset)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		t.Parallel()
+
+		f := NewFaultInjector(0)
+		remove := f.Inject(Rule{Fault: FaultDial})
+		require.ErrorIs(t, f.dial("", ""), ErrInjected)
+
+		remove()
+		require.NoError(t, f.dial("", ""))
+	})
+
+	t.Run("Seeded", func(t *testing.T) {
+		t.Parallel()
+
+		roll := func(seed int64) (fails []bool) {
+			f := NewFaultInjector(seed)
+			f.Inject(Rule{Fault: FaultOpenStream, Probability: .5})
+			for i := 0; i < 64; i++ {
+				fails = append(fails, f.openStream("", "") != nil)
+			}
+			return
+		}
+
+		require.Equal(t, roll(42), roll(42))
+		require.Contains(t, roll(42), true)
+		require.Contains(t, roll(42), false)
+	})
+
+	t.Run("Protocol", func(t *testing.T) {
+		t.Parallel()
+
+		f := NewFaultInjector(0)
+		f.Inject(Rule{Fault: FaultResetStream, Protocol: "/other"})
+		f.Inject(Rule{Fault: FaultDial, Protocol: "/test"})
+
+		require.NoError(t, f.dial("", ""), "protocol rules should not match dials")
+
+		limit, _ := f.write("", "", "/test", 0, 1)
+		require.Negative(t, limit, "rule should not match other protocol")
+
+		limit, rule := f.write("", "", "/other", 0, 1)
+		require.Zero(t, limit)
+		require.Equal(t, FaultResetStream, rule.Fault)
+	})
+}
+
+func TestSniff(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name  string
+		msgs  []string
+		proto string
+	}{
+		{name: "Accept", msgs: []string{multistreamID, "/test/1.0.0"}, proto: "/test/1.0.0"},
+		{name: "Reject", msgs: []string{multistreamID, "na", "/test/2.0.0"}, proto: "/test/2.0.0"},
+		{name: "NotMultistream", msgs: []string{"hello"}, proto: ""},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var info streamInfo
+			for _, msg := range tt.msgs {
+				// feed one byte at a time to exercise buffering
+				for _, b := range delimit(msg) {
+					info.sniff([]byte{b})
+				}
+			}
+
+			require.Equal(t, tt.proto, string(info.Protocol()))
+		})
+	}
+}
+
+func delimit(msg string) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, uint64(len(msg)+1))
+	return append(append(b[:n], msg...), '\n')
+}
@@ -0,0 +1,248 @@
+package inproc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/mikelsr/go-libp2p/core/crypto"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/sec"
+	"github.com/mikelsr/go-libp2p/core/transport"
+	"github.com/mikelsr/go-libp2p/p2p/muxer/yamux"
+	"github.com/mikelsr/go-libp2p/p2p/net/upgrader"
+	"github.com/mikelsr/go-libp2p/p2p/security/noise"
+	"github.com/mikelsr/go-libp2p/p2p/transport/tcp"
+	"github.com/multiformats/go-multiaddr"
+	mafmt "github.com/multiformats/go-multiaddr-fmt"
+)
+
+var errUnsupported = errors.New("gateway: only TCP addresses are supported")
+
+// Gateway bridges the hosts in an Env to the real network, without
+// giving them a real transport.  Conns are secured with Noise and
+// multiplexed with Yamux on the TCP side, so that external peers see a
+// normal libp2p endpoint.  Only TCP is supported; QUIC addresses are
+// rejected.
+//
+// Conns from the real network are subject to the Env's faults, links
+// and scheduler when they are accepted.  They are not tracked or
+// included in snapshots, and links do not delay their streams.
+type Gateway struct {
+	env ExtendedEnv
+	opt []Option // for the transports that Import binds
+
+	ctx    context.Context // canceled by Close
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closers []io.Closer
+	tpts    map[peer.ID]*tcp.TcpTransport // by the identity that they secure conns with
+}
+
+// NewGateway returns a gateway for the hosts in env.  Options apply to
+// the transports that Import binds, e.g. WithClock to renew their
+// leases against a mock clock.
+func NewGateway(env Env, opt ...Option) *Gateway {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Gateway{
+		env:    extend(env),
+		opt:    append(opt, WithEnv(env)),
+		ctx:    ctx,
+		cancel: cancel,
+		tpts:   make(map[peer.ID]*tcp.TcpTransport),
+	}
+}
+
+// Expose accepts TCP conns on laddr, e.g. "/ip4/127.0.0.1/tcp/0", and
+// delivers them to the host that listens on the inproc address target,
+// as if they had been dialed in the Env.  External peers authenticate
+// the host by its own peer ID.  Expose returns the TCP address on which
+// the gateway listens.
+func (g *Gateway) Expose(target, laddr multiaddr.Multiaddr) (multiaddr.Multiaddr, error) {
+	g.env.Lock()
+	bound, ok := g.env.Lookup(target)
+	g.env.Unlock()
+
+	if !ok {
+		return nil, ErrRefused
+	}
+
+	if !mafmt.TCP.Matches(laddr) {
+		return nil, errUnsupported
+	}
+
+	bound.mu.RLock()
+	l, ok := lookupAddr(bound.ls, target)
+	bound.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrRefused // not a libp2p listener
+	}
+
+	tpt, err := g.transport(bound.pk)
+	if err != nil {
+		return nil, err
+	}
+
+	tl, err := tpt.Listen(laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	g.closers = append(g.closers, tl)
+	g.mu.Unlock()
+
+	// stop accepting as soon as the inproc listener closes
+	go func() {
+		select {
+		case <-l.cq:
+			tl.Close()
+		case <-g.ctx.Done():
+		}
+	}()
+
+	go func() {
+		for {
+			c, err := tl.Accept()
+			if err != nil {
+				return
+			}
+
+			// Deliver each conn separately, so that one that the
+			// scheduler or the listener's backlog holds back does not
+			// hold back the others.
+			key := bound.nextSource()
+			go func() {
+				if err := l.acceptExternal(g.ctx, c, key); err != nil {
+					c.Close()
+				}
+			}()
+		}
+	}()
+
+	return tl.Multiaddr(), nil
+}
+
+// Import binds the inproc address laddr, and forwards dials to it over
+// TCP to the libp2p node at raddr, e.g. "/ip4/127.0.0.1/tcp/4001".
+// Dialers authenticate to the node with their own identity, so they
+// must specify its peer ID.
+func (g *Gateway) Import(laddr, raddr multiaddr.Multiaddr) error {
+	laddr, err := Resolve(laddr)
+	if err != nil {
+		return err
+	}
+
+	if !mafmt.TCP.Matches(raddr) {
+		return errUnsupported
+	}
+
+	t := newTransport(nil, nil, g.opt)
+	t.forward = func(ctx context.Context, dialer *Transport, p peer.ID) (transport.CapableConn, error) {
+		if p == "" {
+			return nil, errors.New("gateway: dialing an imported address requires a peer ID")
+		}
+
+		tpt, err := g.transport(dialer.pk)
+		if err != nil {
+			return nil, err
+		}
+
+		return tpt.Dial(ctx, raddr, p)
+	}
+
+	g.env.Lock()
+	defer g.env.Unlock()
+
+	if !g.env.Bind(laddr, t) {
+		return ErrInUse
+	}
+
+	b := &binding{env: g.env, ma: laddr, t: t, done: make(chan struct{})}
+	t.renew(laddr, b.done, b.Close)
+
+	g.mu.Lock()
+	g.closers = append(g.closers, b)
+	g.mu.Unlock()
+
+	return nil
+}
+
+// Close stops accepting TCP conns, and frees the addresses bound by
+// Import.  Conns that were already established are not affected.
+func (g *Gateway) Close() error {
+	g.cancel()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, c := range g.closers {
+		c.Close()
+	}
+	g.closers = nil
+
+	return nil
+}
+
+// transport returns the gateway's TCP transport for the identity pk,
+// creating it on first use.
+func (g *Gateway) transport(pk crypto.PrivKey) (*tcp.TcpTransport, error) {
+	id, err := peer.IDFromPrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if tpt, ok := g.tpts[id]; ok {
+		return tpt, nil
+	}
+
+	tpt, err := newTCPTransport(pk)
+	if err == nil {
+		g.tpts[id] = tpt
+	}
+
+	return tpt, err
+}
+
+func newTCPTransport(pk crypto.PrivKey) (*tcp.TcpTransport, error) {
+	muxers := []upgrader.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}
+
+	security, err := noise.New(noise.ID, pk, muxers)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := upgrader.New([]sec.SecureTransport{security}, muxers, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return tcp.NewTCPTransport(u, nil)
+}
+
+// binding frees an address when closed.
+type binding struct {
+	env ExtendedEnv
+	ma  multiaddr.Multiaddr
+	t   *Transport
+
+	once sync.Once
+	done chan struct{} // closed when the binding is freed
+}
+
+func (b *binding) Close() error {
+	b.once.Do(func() {
+		b.env.Lock()
+		defer b.env.Unlock()
+
+		close(b.done)
+		b.env.Release(b.ma, b.t)
+	})
+	return nil
+}
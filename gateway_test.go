@@ -0,0 +1,160 @@
+package inproc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/go-libp2p"
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p/core/host"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	faults := inproc.NewFaultInjector(0)
+	env := inproc.NewEnv(inproc.WithFaults(faults))
+	gw := inproc.NewGateway(env)
+	defer gw.Close()
+
+	h0, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h0.Close()
+
+	external, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer external.Close()
+
+	echo := func(s network.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	}
+	h0.SetStreamHandler("/echo", echo)
+	external.SetStreamHandler("/echo", echo)
+
+	t.Run("Expose", func(t *testing.T) {
+		maddr, err := gw.Expose(h0.Addrs()[0], multiaddr.StringCast("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+
+		err = external.Connect(ctx, peer.AddrInfo{ID: h0.ID(), Addrs: []multiaddr.Multiaddr{maddr}})
+		require.NoError(t, err)
+
+		testEcho(t, external, h0.ID())
+	})
+
+	t.Run("ExposeFault", func(t *testing.T) {
+		maddr, err := gw.Expose(h0.Addrs()[0], multiaddr.StringCast("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+
+		remove := faults.Inject(inproc.Rule{Fault: inproc.FaultAccept, Local: h0.ID()})
+		defer remove()
+
+		other, err := libp2p.New(libp2p.NoListenAddrs)
+		require.NoError(t, err)
+		defer other.Close()
+
+		// the TCP handshake succeeds, but the conn is dropped before h0
+		// accepts it
+		other.Connect(ctx, peer.AddrInfo{ID: h0.ID(), Addrs: []multiaddr.Multiaddr{maddr}})
+		require.Eventually(t, func() bool {
+			return len(other.Network().ConnsToPeer(h0.ID())) == 0
+		}, time.Second, 10*time.Millisecond, "should inject accept faults")
+		require.Empty(t, h0.Network().ConnsToPeer(other.ID()))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		quic := multiaddr.StringCast("/ip4/127.0.0.1/udp/0/quic-v1")
+
+		_, err := gw.Expose(h0.Addrs()[0], quic)
+		require.Error(t, err, "should reject QUIC listen address")
+		require.Error(t, gw.Import(multiaddr.StringCast("/inproc/quic"), quic),
+			"should reject QUIC dial address")
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		imported := multiaddr.StringCast("/inproc/external")
+		require.NoError(t, gw.Import(imported, external.Addrs()[0]))
+
+		h1, err := newTestHost(env)
+		require.NoError(t, err)
+		defer h1.Close()
+
+		err = h1.Connect(ctx, peer.AddrInfo{ID: external.ID(), Addrs: []multiaddr.Multiaddr{imported}})
+		require.NoError(t, err)
+
+		testEcho(t, h1, external.ID())
+	})
+}
+
+func TestGatewayExpose(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sched := inproc.NewScheduler(0)
+	env := inproc.NewEnv(inproc.WithScheduler(sched))
+	gw := inproc.NewGateway(env)
+	defer gw.Close()
+
+	h0, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h0.Close()
+
+	maddr, err := gw.Expose(h0.Addrs()[0], multiaddr.StringCast("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+
+	t.Run("Concurrent", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			external, err := libp2p.New(libp2p.NoListenAddrs)
+			require.NoError(t, err)
+			defer external.Close()
+
+			go external.Connect(ctx, peer.AddrInfo{ID: h0.ID(), Addrs: []multiaddr.Multiaddr{maddr}})
+		}
+
+		// the scheduler holds back both accepts until they are released
+		wctx, wcancel := context.WithTimeout(ctx, time.Second)
+		defer wcancel()
+		require.NoError(t, sched.Wait(wctx, 2), "should deliver conns concurrently")
+		go sched.Run(ctx)
+	})
+
+	t.Run("ListenerClosed", func(t *testing.T) {
+		require.NoError(t, h0.Close())
+
+		require.Eventually(t, func() bool {
+			c, err := manet.Dial(maddr)
+			if err == nil {
+				c.Close()
+			}
+			return err != nil
+		}, time.Second, 10*time.Millisecond, "should stop accepting TCP conns")
+	})
+}
+
+func testEcho(t *testing.T, h host.Host, id peer.ID) {
+	t.Helper()
+
+	s, err := h.NewStream(context.Background(), id, "/echo")
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = io.WriteString(s, "hello")
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
@@ -3,15 +3,17 @@ module github.com/mikelsr/go-libp2p-inproc-transport
 go 1.18
 
 require (
+	github.com/benbjohnson/clock v1.3.5
 	github.com/google/uuid v1.3.0
 	github.com/lthibault/util v0.0.12
 	github.com/mikelsr/go-libp2p v0.28.1-0.20230701164104-d35ccfab977a
 	github.com/multiformats/go-multiaddr v0.9.0
+	github.com/multiformats/go-multiaddr-fmt v0.1.0
 	github.com/stretchr/testify v1.8.2
+	google.golang.org/grpc v1.56.3
 )
 
 require (
-	github.com/benbjohnson/clock v1.3.5 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/containerd/cgroups v1.1.0 // indirect
@@ -60,7 +62,6 @@ require (
 	github.com/multiformats/go-base32 v0.1.0 // indirect
 	github.com/multiformats/go-base36 v0.2.0 // indirect
 	github.com/multiformats/go-multiaddr-dns v0.3.1 // indirect
-	github.com/multiformats/go-multiaddr-fmt v0.1.0 // indirect
 	github.com/multiformats/go-multibase v0.2.0 // indirect
 	github.com/multiformats/go-multicodec v0.9.0 // indirect
 	github.com/multiformats/go-multihash v0.2.3 // indirect
@@ -92,6 +93,7 @@ require (
 	golang.org/x/sys v0.9.0 // indirect
 	golang.org/x/text v0.10.0 // indirect
 	golang.org/x/tools v0.10.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	lukechampine.com/blake3 v1.2.1 // indirect
@@ -0,0 +1,83 @@
+package inproc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// touch records activity on the conn, at both of its ends.
+func (c *conn) touch() {
+	atomic.StoreInt64(&c.active, c.l.t.clock.Now().UnixNano())
+	atomic.StoreInt64(&c.remote.active, c.remote.l.t.clock.Now().UnixNano())
+}
+
+// idle returns how long the conn has had no open streams at either
+// end, and no keepalives.
+func (c *conn) idle() time.Duration {
+	if n, _ := c.numStreams(); n > 0 {
+		return 0
+	}
+	if n, _ := c.remote.numStreams(); n > 0 {
+		return 0
+	}
+
+	return c.l.t.clock.Since(time.Unix(0, atomic.LoadInt64(&c.active)))
+}
+
+// monitor enforces the transport's idle timeout on the conn, and sends
+// its keepalives.  It returns when the conn is closed at either end.
+func (c *conn) monitor() {
+	t := c.l.t
+
+	var (
+		idle  *clock.Timer
+		idleC <-chan time.Time
+		pingC <-chan time.Time
+	)
+
+	if t.idleTimeout > 0 {
+		idle = t.clock.Timer(t.idleTimeout)
+		defer idle.Stop()
+		idleC = idle.C
+	}
+
+	if t.keepAlive > 0 {
+		ticker := t.clock.Ticker(t.keepAlive)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.cq:
+			return
+		case <-c.remote.cq:
+			return
+
+		case <-pingC:
+			if link, _ := c.link(); link.Down {
+				c.hangUp() // the keepalive timed out
+				return
+			}
+			c.touch()
+
+		case <-idleC:
+			if d := c.idle(); d < t.idleTimeout {
+				idle.Reset(t.idleTimeout - d)
+				continue
+			}
+			c.hangUp()
+			return
+		}
+	}
+}
+
+// hangUp closes both ends of the conn, as when the network drops it.
+// Both ends may hang up at once, if both of their transports enforce
+// an idle timeout.
+func (c *conn) hangUp() {
+	c.Close()
+	c.remote.Close()
+}
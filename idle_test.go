@@ -0,0 +1,72 @@
+package inproc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	_, dc, _, lc := newConnTest(t, WithClock(clk), WithIdleTimeout(time.Minute))
+
+	s, a := openTestStream(t, dc, lc)
+	require.Never(t, func() bool {
+		clk.Add(time.Minute)
+		return lc.IsClosed() || dc.IsClosed()
+	}, 50*time.Millisecond, time.Millisecond, "should not close a conn with open streams")
+
+	require.NoError(t, s.Close())
+	require.NoError(t, a.Close())
+	require.Eventually(t, func() bool {
+		clk.Add(10 * time.Second)
+		return lc.IsClosed() && dc.IsClosed()
+	}, time.Second, time.Millisecond, "should close both ends when idle")
+}
+
+func TestKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	_, dc, _, lc := newConnTest(t,
+		WithClock(clk),
+		WithIdleTimeout(time.Minute),
+		WithKeepAlive(10*time.Second))
+
+	require.Never(t, func() bool {
+		clk.Add(time.Second)
+		return lc.IsClosed() || dc.IsClosed()
+	}, 100*time.Millisecond, time.Millisecond, "keepalives should keep idle conn open")
+
+	env := lc.l.t.env
+	env.Links().Set(lc.localEndpoint(), lc.remoteEndpoint(), Link{Down: true})
+	require.Eventually(t, func() bool {
+		clk.Add(time.Second)
+		return lc.IsClosed() && dc.IsClosed()
+	}, time.Second, time.Millisecond, "should close conn when a keepalive fails")
+}
+
+func TestHangUp(t *testing.T) {
+	t.Parallel()
+
+	_, dc, _, lc := newConnTest(t)
+
+	// both ends time out at once
+	var wg sync.WaitGroup
+	for _, c := range []*conn{dc, lc, dc, lc} {
+		wg.Add(1)
+		go func(c *conn) {
+			defer wg.Done()
+			c.hangUp()
+		}(c)
+	}
+	wg.Wait()
+
+	require.True(t, dc.IsClosed())
+	require.True(t, lc.IsClosed())
+}
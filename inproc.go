@@ -1,6 +1,9 @@
 package inproc
 
 import (
+	"time"
+
+	"github.com/benbjohnson/clock"
 	"github.com/mikelsr/go-libp2p/core/crypto"
 	"github.com/mikelsr/go-libp2p/core/host"
 	"github.com/mikelsr/go-libp2p/core/transport"
@@ -14,18 +17,25 @@ type Factory func(host.Host, crypto.PrivKey) transport.Transport
 // libp2p.Transport function.
 func New(opt ...Option) Factory {
 	return func(h host.Host, pk crypto.PrivKey) transport.Transport {
-		t := &Transport{
-			h:  h,
-			pk: pk,
-			ls: make(map[string]*listener),
-		}
+		return newTransport(h, pk, opt)
+	}
+}
 
-		for _, option := range withDefaults(opt) {
-			option(t)
-		}
+func newTransport(h host.Host, pk crypto.PrivKey, opt []Option) *Transport {
+	t := &Transport{
+		h:   h,
+		pk:  pk,
+		ls:  make(map[string]*listener),
+		nls: make(map[string]*netListener),
+		cs:  make(map[*conn]struct{}),
+	}
 
-		return t
+	for _, option := range withDefaults(opt) {
+		option(t)
 	}
+
+	t.src = t.env.Scheduler().newSource()
+	return t
 }
 
 // Option type for Transport.
@@ -34,12 +44,113 @@ type Option func(*Transport)
 // WithEnv sets the transport's environment.
 func WithEnv(env Env) Option {
 	return func(t *Transport) {
-		t.env = env
+		t.env = extend(env)
+	}
+}
+
+// WithClock sets the clock against which the transport measures
+// stream deadlines and simulated network delays.  Passing a shared
+// *clock.Mock to every transport in an Env allows tests to advance
+// simulated time without sleeping.  Defaults to the system clock.
+func WithClock(clk clock.Clock) Option {
+	return func(t *Transport) {
+		t.clock = clk
+	}
+}
+
+// WithChunker splits the data written to the transport's streams into
+// chunks, each of which is delivered to the remote reader separately.
+// This exposes protocols that wrongly assume that a single read
+// returns a whole message.  By default, the remote reader receives as
+// much of each write as fits in its buffer.
+func WithChunker(c Chunker) Option {
+	return func(t *Transport) {
+		t.chunk = c
+	}
+}
+
+// DefaultLinger is the default time for which closing a stream waits
+// for an in-progress write to be read before interrupting it.
+const DefaultLinger = time.Second
+
+// WithLinger sets how long Close and CloseWrite wait for an in-progress
+// write to be consumed by the remote reader, as measured by the
+// transport's clock.  Writes that are still in flight are interrupted,
+// and their data is lost.  A linger of zero interrupts them at once.
+// Defaults to DefaultLinger.
+func WithLinger(d time.Duration) Option {
+	return func(t *Transport) {
+		t.linger = d
+	}
+}
+
+// Default stream limits, which match yamux's.
+const (
+	DefaultMaxStreams    = 1000
+	DefaultAcceptBacklog = 256
+)
+
+// WithMaxStreams limits the number of streams that the remote end of
+// each of the transport's conns may have open at once.  Attempts to
+// open more streams fail with ErrStreamsExhausted.  A limit of zero
+// removes the limit.  Defaults to DefaultMaxStreams.
+func WithMaxStreams(n int) Option {
+	return func(t *Transport) {
+		t.maxStreams = n
+	}
+}
+
+// WithAcceptBacklog sets the number of streams on each of the
+// transport's conns that may wait for AcceptStream.  Opening a stream
+// while the backlog is full fails with ErrStreamsExhausted, rather
+// than blocking.  A backlog of zero removes the limit.  Defaults to
+// DefaultAcceptBacklog.
+func WithAcceptBacklog(n int) Option {
+	return func(t *Transport) {
+		t.backlog = n
+	}
+}
+
+// WithStreamFilter lets the transport refuse the streams that the
+// remote ends of its conns open.  Before a stream is queued to be
+// accepted, f is called with the local end of the conn.  If f returns
+// false, OpenStream fails with ErrStreamRefused at the remote end, and
+// the stream is never accepted.  f must not block.
+func WithStreamFilter(f func(transport.CapableConn) bool) Option {
+	return func(t *Transport) {
+		t.filter = f
+	}
+}
+
+// WithIdleTimeout closes the transport's conns after they have had no
+// open streams, at either end, for d, as measured by the transport's
+// clock.  Both ends of the conn are closed, as when a NAT drops an
+// idle TCP connection.  Keepalives sent by either end count as
+// activity.  A timeout of zero, the default, keeps idle conns open.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(t *Transport) {
+		t.idleTimeout = d
+	}
+}
+
+// WithKeepAlive makes the transport's conns send a keepalive every
+// interval, as yamux does, so that an idle timeout that is longer than
+// interval does not close them.  A keepalive fails if the link that
+// carries the conn is down when it is due.  Then, as yamux does when a
+// ping times out, the conn is closed at both ends.  An interval of
+// zero, the default, disables keepalives.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(t *Transport) {
+		t.keepAlive = interval
 	}
 }
 
 func withDefaults(opt []Option) []Option {
 	return append([]Option{
 		WithEnv(globalEnv),
+		WithClock(clock.New()),
+		WithLinger(DefaultLinger),
+		WithMaxStreams(DefaultMaxStreams),
+		WithAcceptBacklog(DefaultAcceptBacklog),
 	}, opt...)
 }
@@ -10,6 +10,7 @@ import (
 	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
 	"github.com/mikelsr/go-libp2p/core/host"
 	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,6 +52,28 @@ func TestIntegration(t *testing.T) {
 
 		testFunc(t, h0, h1)
 	})
+
+	t.Run("BasicEnv", func(t *testing.T) {
+		t.Parallel()
+
+		// an Env that only implements the methods of inproc.Env
+		env := struct{ inproc.Env }{inproc.NewEnv()}
+		tpt := inproc.New(inproc.WithEnv(env))
+
+		h0, err := libp2p.New(
+			libp2p.Transport(tpt),
+			libp2p.ListenAddrStrings("/inproc/h0"))
+		require.NoError(t, err)
+
+		h1, err := libp2p.New(
+			libp2p.Transport(tpt),
+			libp2p.NoListenAddrs)
+		require.NoError(t, err)
+
+		testFunc(t, h0, h1)
+		require.NotContains(t, env.List(), multiaddr.StringCast("/inproc/h0"),
+			"should free addresses when closed")
+	})
 }
 
 func testFunc(t *testing.T, h0, h1 host.Host) {
@@ -0,0 +1,147 @@
+// Package inprocnet builds networks of libp2p hosts that communicate
+// over the inproc transport, for use in tests.
+package inprocnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mikelsr/go-libp2p"
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p/core/host"
+	"github.com/mikelsr/go-libp2p/core/peerstore"
+)
+
+// Network is a set of libp2p hosts that share an isolated inproc.Env.
+type Network struct {
+	Env   inproc.ExtendedEnv
+	Hosts []host.Host
+}
+
+// Option configures a Network.
+type Option func(*config)
+
+type config struct {
+	env  []inproc.EnvOption
+	tpt  []inproc.Option
+	host []libp2p.Option
+	link inproc.Link
+	leak *[]LeakOption // nil if leaks are not checked
+}
+
+// WithEnvOptions configures the Env that is shared by the hosts.
+func WithEnvOptions(opt ...inproc.EnvOption) Option {
+	return func(c *config) {
+		c.env = append(c.env, opt...)
+	}
+}
+
+// WithTransportOptions configures each host's inproc transport.  The
+// Env is always set by the Network.
+func WithTransportOptions(opt ...inproc.Option) Option {
+	return func(c *config) {
+		c.tpt = append(c.tpt, opt...)
+	}
+}
+
+// WithDefaultLink sets the properties of all links between hosts,
+// unless overridden with SetLink.
+func WithDefaultLink(l inproc.Link) Option {
+	return func(c *config) {
+		c.link = l
+	}
+}
+
+// WithHostOptions passes additional options to libp2p.New for each
+// host.
+func WithHostOptions(opt ...libp2p.Option) Option {
+	return func(c *config) {
+		c.host = append(c.host, opt...)
+	}
+}
+
+// WithLeakCheck reports the conns and streams that are open when the
+// test completes, before the hosts are closed.  See CheckLeaks.
+func WithLeakCheck(opt ...LeakOption) Option {
+	return func(c *config) {
+		c.env = append(c.env, inproc.WithTracker(inproc.NewTracker()))
+		c.leak = &opt
+	}
+}
+
+// New creates n hosts that share a fresh Env.  The hosts listen on
+// random inproc addresses, and are not linked to one another.  They
+// are closed when the test completes.
+func New(t testing.TB, n int, opt ...Option) *Network {
+	t.Helper()
+
+	var c config
+	for _, option := range opt {
+		option(&c)
+	}
+
+	net := &Network{Env: inproc.NewEnv(c.env...)}
+	net.Env.Links().SetDefault(c.link)
+	t.Cleanup(func() {
+		for _, h := range net.Hosts {
+			h.Close()
+		}
+	})
+
+	tpt := inproc.New(append(c.tpt, inproc.WithEnv(net.Env))...)
+	for i := 0; i < n; i++ {
+		h, err := libp2p.New(append([]libp2p.Option{
+			libp2p.NoTransports,
+			libp2p.Transport(tpt),
+			libp2p.ListenAddrStrings("/inproc/~"),
+		}, c.host...)...)
+		if err != nil {
+			t.Fatalf("create host %d: %s", i, err)
+		}
+
+		net.Hosts = append(net.Hosts, h)
+	}
+
+	// registered after the hosts' cleanup, so that it runs first
+	if c.leak != nil {
+		CheckLeaks(t, net.Env, *c.leak...)
+	}
+
+	return net
+}
+
+// Link makes the hosts aware of each other's addresses according to
+// the topology, so that each pair of linked hosts can open streams
+// to one another.  Connections are established lazily, on first use.
+func (net *Network) Link(top Topology) {
+	for _, e := range top(len(net.Hosts)) {
+		h0, h1 := net.Hosts[e[0]], net.Hosts[e[1]]
+		h0.Peerstore().AddAddrs(h1.ID(), h1.Addrs(), peerstore.PermanentAddrTTL)
+		h1.Peerstore().AddAddrs(h0.ID(), h0.Addrs(), peerstore.PermanentAddrTTL)
+	}
+}
+
+// Connect links the hosts according to the topology, and establishes
+// a connection between each pair of linked hosts.
+func (net *Network) Connect(ctx context.Context, top Topology) error {
+	net.Link(top)
+
+	for _, e := range top(len(net.Hosts)) {
+		h0, h1 := net.Hosts[e[0]], net.Hosts[e[1]]
+		if err := h0.Connect(ctx, *host.InfoFromHost(h1)); err != nil {
+			return fmt.Errorf("connect %d to %d: %w", e[0], e[1], err)
+		}
+	}
+
+	return nil
+}
+
+// SetLink sets the properties of the link between hosts i and j.  It
+// may be called at any time to change network conditions.
+func (net *Network) SetLink(i, j int, l inproc.Link) {
+	net.Env.Links().Set(
+		inproc.Endpoint{Peer: net.Hosts[i].ID()},
+		inproc.Endpoint{Peer: net.Hosts[j].ID()},
+		l)
+}
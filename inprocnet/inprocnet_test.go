@@ -0,0 +1,110 @@
+package inprocnet_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p-inproc-transport/inprocnet"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/p2p/net/swarm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnect(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name  string
+		top   inprocnet.Topology
+		peers []int // expected number of peers for each host
+	}{
+		{name: "FullMesh", top: inprocnet.FullMesh, peers: []int{3, 3, 3, 3}},
+		{name: "Ring", top: inprocnet.Ring, peers: []int{2, 2, 2, 2}},
+		{name: "Star", top: inprocnet.Star, peers: []int{3, 1, 1, 1}},
+		{name: "Adjacency", top: inprocnet.Adjacency([][]int{{1}, {0, 2}}), peers: []int{1, 2, 1, 0}},
+		{name: "AdjacencyOutOfRange", top: inprocnet.Adjacency([][]int{{-1, 1, 2}, {}}), peers: []int{1, 1}},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			net := inprocnet.New(t, len(tt.peers))
+			require.NoError(t, net.Connect(context.Background(), tt.top))
+
+			for i, h := range net.Hosts {
+				assert.Len(t, h.Network().Peers(), tt.peers[i],
+					"host %d has wrong number of peers", i)
+			}
+		})
+	}
+}
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+
+	net := inprocnet.New(t, 2)
+	net.Link(inprocnet.FullMesh)
+
+	h0, h1 := net.Hosts[0], net.Hosts[1]
+	h1.SetStreamHandler("/test", func(s network.Stream) { s.Close() })
+
+	require.Empty(t, h0.Network().Peers(), "link should not connect")
+
+	s, err := h0.NewStream(context.Background(), h1.ID(), "/test")
+	require.NoError(t, err)
+	s.Close()
+}
+
+func TestRandomGraph(t *testing.T) {
+	t.Parallel()
+
+	top := inprocnet.RandomGraph(.5, 42)
+	require.Equal(t, top(16), top(16), "should be reproducible")
+	require.NotEmpty(t, top(16))
+	require.Less(t, len(top(16)), len(inprocnet.FullMesh(16)))
+}
+
+func TestSetLink(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	net := inprocnet.New(t, 2)
+	h0, h1 := net.Hosts[0], net.Hosts[1]
+
+	t.Run("Down", func(t *testing.T) {
+		net.SetLink(0, 1, inproc.Link{Down: true})
+		require.Error(t, net.Connect(ctx, inprocnet.FullMesh))
+
+		net.SetLink(0, 1, inproc.Link{})
+		h0.Network().(interface{ Backoff() *swarm.DialBackoff }).Backoff().Clear(h1.ID())
+		require.NoError(t, net.Connect(ctx, inprocnet.FullMesh))
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		const latency = 20 * time.Millisecond
+
+		h1.SetStreamHandler("/echo", func(s network.Stream) {
+			defer s.Close()
+			io.Copy(s, s)
+		})
+
+		s, err := h0.NewStream(ctx, h1.ID(), "/echo")
+		require.NoError(t, err)
+		defer s.Close()
+
+		net.SetLink(0, 1, inproc.Link{Latency: latency})
+		start := time.Now()
+
+		_, err = s.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(s, make([]byte, 4))
+		require.NoError(t, err)
+
+		require.GreaterOrEqual(t, time.Since(start), 2*latency,
+			"round trip should cross the link twice")
+	})
+}
@@ -0,0 +1,65 @@
+package inprocnet
+
+import (
+	"testing"
+	"time"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+)
+
+// LeakOption configures CheckLeaks.
+type LeakOption func(*leakConfig)
+
+type leakConfig struct {
+	blocked time.Duration // zero if blocked streams do not fail the test
+}
+
+// FailBlocked fails the test if a stream's read or write has been
+// blocked for at least d when the test completes.
+func FailBlocked(d time.Duration) LeakOption {
+	return func(c *leakConfig) {
+		c.blocked = d
+	}
+}
+
+// CheckLeaks reports the conns and streams that are still open in env
+// when the test completes, with the stacks that opened them.  The Env
+// must have been created with inproc.WithTracker.
+//
+// Cleanups run in reverse order, so CheckLeaks inspects hosts that
+// are closed by a cleanup only if it is called before they are
+// created.  Use WithLeakCheck to inspect a Network's hosts before they
+// are closed.
+func CheckLeaks(t testing.TB, env inproc.Env, opt ...LeakOption) {
+	t.Helper()
+
+	var c leakConfig
+	for _, option := range opt {
+		option(&c)
+	}
+
+	var tr *inproc.Tracker
+	if ext, ok := env.(inproc.ExtendedEnv); ok {
+		tr = ext.Tracker()
+	}
+	if tr == nil {
+		t.Fatal("inprocnet: CheckLeaks requires an Env with a Tracker")
+	}
+
+	t.Cleanup(func() {
+		for _, trace := range tr.Conns() {
+			t.Logf("open conn %s, opened at:\n%s", trace.Label, trace.Stack)
+		}
+
+		for _, trace := range tr.Streams() {
+			if c.blocked > 0 && trace.Blocked >= c.blocked {
+				t.Errorf("stream %s (%s) blocked for %s, opened at:\n%s",
+					trace.Label, trace.Protocol, trace.Blocked, trace.Stack)
+				continue
+			}
+
+			t.Logf("open stream %s (%s), opened at:\n%s",
+				trace.Label, trace.Protocol, trace.Stack)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package inprocnet_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p-inproc-transport/inprocnet"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLeaks(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		opt  []inprocnet.LeakOption
+		fail bool
+	}{
+		{name: "Report"},
+		{name: "FailBlocked", opt: []inprocnet.LeakOption{inprocnet.FailBlocked(time.Millisecond)}, fail: true},
+		{name: "BelowThreshold", opt: []inprocnet.LeakOption{inprocnet.FailBlocked(time.Hour)}},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			clk := clock.NewMock()
+			clk.Set(time.Now()) // the tracker takes a zero time to mean not blocked
+			rec := &recorder{T: t}
+			net := inprocnet.New(rec, 2,
+				inprocnet.WithLeakCheck(tt.opt...),
+				inprocnet.WithTransportOptions(inproc.WithClock(clk)))
+			h0, h1 := net.Hosts[0], net.Hosts[1]
+			require.NoError(t, net.Connect(context.Background(), inprocnet.FullMesh))
+
+			copying := make(chan struct{})
+			h1.SetStreamHandler("/test/leak", func(s network.Stream) {
+				close(copying)
+				io.Copy(io.Discard, s) // blocks until the host is closed
+			})
+
+			s, err := h0.NewStream(context.Background(), h1.ID(), "/test/leak")
+			require.NoError(t, err)
+			_, err = s.Write([]byte("x")) // negotiate, then leak the stream
+			require.NoError(t, err)
+			<-copying
+
+			// The handler may not have reached its read yet, so advance
+			// the clock until the read has been blocked for a while.
+			tr := net.Env.(inproc.ExtendedEnv).Tracker()
+			require.Eventually(t, func() bool {
+				clk.Add(time.Second)
+				for _, trace := range tr.Streams() {
+					if trace.Protocol == "/test/leak" && trace.Blocked > 0 {
+						return true
+					}
+				}
+				return false
+			}, time.Second, time.Millisecond)
+
+			rec.cleanup()
+			require.Equal(t, tt.fail, rec.failed, "logs: %v", rec.logs)
+			require.NotEmpty(t, rec.logs, "should report open stream")
+		})
+	}
+}
+
+// recorder captures the results of a test's cleanups, so that the
+// test can check them.
+type recorder struct {
+	*testing.T
+	cleanups []func()
+	logs     []string
+	failed   bool
+}
+
+func (r *recorder) Cleanup(f func()) { r.cleanups = append(r.cleanups, f) }
+
+func (r *recorder) Logf(format string, args ...any) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failed = true
+	r.Logf(format, args...)
+}
+
+func (r *recorder) cleanup() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
@@ -0,0 +1,89 @@
+package inprocnet
+
+import "math/rand"
+
+// Topology returns the edges of a graph on n nodes.  For each edge
+// {i, j}, host i dials host j.
+type Topology func(n int) [][2]int
+
+// FullMesh links every host to every other host.
+func FullMesh(n int) (edges [][2]int) {
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			edges = append(edges, [2]int{i, j})
+		}
+	}
+
+	return
+}
+
+// Ring links each host to the next, and the last host to the first.
+func Ring(n int) (edges [][2]int) {
+	if n < 2 {
+		return nil
+	}
+
+	if n == 2 {
+		return [][2]int{{0, 1}}
+	}
+
+	for i := 0; i < n; i++ {
+		edges = append(edges, [2]int{i, (i + 1) % n})
+	}
+
+	return
+}
+
+// Star links the first host to every other host.
+func Star(n int) (edges [][2]int) {
+	for i := 1; i < n; i++ {
+		edges = append(edges, [2]int{i, 0})
+	}
+
+	return
+}
+
+// RandomGraph returns an Erdős–Rényi topology, in which each pair of
+// hosts is linked with probability p, as chosen by a PRNG seeded with
+// seed.
+func RandomGraph(p float64, seed int64) Topology {
+	return func(n int) (edges [][2]int) {
+		r := rand.New(rand.NewSource(seed))
+		for _, e := range FullMesh(n) {
+			if r.Float64() < p {
+				edges = append(edges, e)
+			}
+		}
+
+		return
+	}
+}
+
+// Adjacency returns a topology in which host i is linked to each host
+// in adj[i].  Links are undirected, so each pair of hosts is linked at
+// most once, regardless of whether it appears in both lists.  Indices
+// outside [0, n) and self-links are ignored.
+func Adjacency(adj [][]int) Topology {
+	return func(n int) (edges [][2]int) {
+		seen := make(map[[2]int]bool)
+		for i, js := range adj {
+			for _, j := range js {
+				if i == j || i >= n || j < 0 || j >= n {
+					continue
+				}
+
+				key := [2]int{i, j}
+				if j < i {
+					key = [2]int{j, i}
+				}
+
+				if !seen[key] {
+					seen[key] = true
+					edges = append(edges, [2]int{i, j})
+				}
+			}
+		}
+
+		return
+	}
+}
@@ -0,0 +1,139 @@
+package inproc
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Binding describes a lease on an address that expired before the
+// transport that held it released it.  This usually means that a host
+// was not closed, or that its listener stopped renewing the lease.
+type Binding struct {
+	Addr    multiaddr.Multiaddr
+	Peer    peer.ID // empty if the transport has no host
+	Bound   time.Time
+	Expired time.Time
+	Stack   string // of the call to Bind
+}
+
+type lease struct {
+	bound, expires time.Time
+	stack          []byte
+}
+
+// WithLeases makes the Env's bindings expire unless they are renewed
+// within ttl, as measured by clk.  Listeners renew their bindings
+// while they are open, so that a host that stops without closing
+// releases its addresses, and dials to them are refused.  Listeners
+// renew against their transport's clock, so tests that use a mock
+// clock should pass it to the transports as well.
+func WithLeases(ttl time.Duration, clk clock.Clock) EnvOption {
+	return func(env *mapEnv) {
+		env.ttl, env.clock = ttl, clk
+	}
+}
+
+func (env *mapEnv) TTL() time.Duration { return env.ttl }
+
+func (env *mapEnv) Renew(ma multiaddr.Multiaddr, t *Transport) bool {
+	env.expire()
+
+	rec, ok := env.bs[ma.String()]
+	if !ok || !rec.bound(t) {
+		return false
+	}
+
+	if env.ttl > 0 {
+		rec.leases[t].expires = env.clock.Now().Add(env.ttl)
+	}
+
+	return true
+}
+
+func (env *mapEnv) Dangling() []Binding {
+	env.Lock()
+	defer env.Unlock()
+
+	env.expire()
+	return append([]Binding(nil), env.dangling...)
+}
+
+// lease a new binding.  The caller must hold the lock.
+func (env *mapEnv) lease(rec *record, t *Transport) {
+	if env.ttl <= 0 {
+		return
+	}
+
+	if rec.leases == nil {
+		rec.leases = make(map[*Transport]*lease)
+	}
+
+	now := env.clock.Now()
+	rec.leases[t] = &lease{
+		bound:   now,
+		expires: now.Add(env.ttl),
+		stack:   debug.Stack(),
+	}
+}
+
+// expire frees the bindings whose leases have expired, and records
+// them as dangling.  The caller must hold the lock.
+func (env *mapEnv) expire() {
+	if env.ttl <= 0 {
+		return
+	}
+
+	now := env.clock.Now()
+	for _, rec := range env.bs {
+		for _, t := range append([]*Transport(nil), rec.Ts...) {
+			l := rec.leases[t]
+			if now.Before(l.expires) {
+				continue
+			}
+
+			env.dangling = append(env.dangling, Binding{
+				Addr:    rec.Addr,
+				Peer:    t.id(),
+				Bound:   l.bound,
+				Expired: l.expires,
+				Stack:   string(l.stack),
+			})
+			env.Release(rec.Addr, t)
+		}
+	}
+}
+
+// renew the transport's lease on laddr until done is closed.  If the
+// lease is lost, renew calls stop.
+func (t *Transport) renew(laddr multiaddr.Multiaddr, done <-chan struct{}, stop func() error) {
+	ttl := t.env.TTL()
+	if ttl <= 0 {
+		return
+	}
+
+	ticker := t.clock.Ticker(ttl / 3)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			t.env.Lock()
+			ok := t.env.Renew(laddr, t)
+			t.env.Unlock()
+
+			if !ok {
+				stop()
+				return
+			}
+		}
+	}()
+}
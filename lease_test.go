@@ -0,0 +1,113 @@
+package inproc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeases(t *testing.T) {
+	t.Parallel()
+
+	const ttl = time.Minute
+	ma := multiaddr.StringCast("/inproc/leased")
+
+	t.Run("Expire", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		env := inproc.NewEnv(inproc.WithLeases(ttl, clk))
+		require.Equal(t, ttl, env.TTL())
+
+		tpt := &inproc.Transport{}
+		require.True(t, env.Bind(ma, tpt))
+
+		clk.Add(ttl / 2)
+		require.True(t, env.Renew(ma, tpt))
+
+		clk.Add(ttl / 2)
+		_, ok := env.Lookup(ma)
+		require.True(t, ok, "renewed lease should not expire")
+
+		clk.Add(ttl)
+		_, ok = env.Lookup(ma)
+		require.False(t, ok, "should free expired binding")
+		require.False(t, env.Renew(ma, tpt), "should not renew expired lease")
+		require.True(t, env.Bind(ma, &inproc.Transport{}), "should allow rebinding")
+
+		dangling := env.Dangling()
+		require.Len(t, dangling, 1)
+		require.True(t, ma.Equal(dangling[0].Addr))
+		require.Contains(t, dangling[0].Stack, "lease_test.go")
+	})
+
+	t.Run("Renew", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		env := inproc.NewEnv(inproc.WithLeases(ttl, clk))
+
+		l, err := inproc.Listen(env, ma, inproc.WithClock(clk))
+		require.NoError(t, err)
+		defer l.Close()
+
+		for i := 0; i < 9; i++ {
+			clk.Add(ttl / 3)
+		}
+
+		env.Lock()
+		_, ok := env.Lookup(ma)
+		env.Unlock()
+		require.True(t, ok, "listener should renew its lease")
+		require.Empty(t, env.Dangling())
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		t.Parallel()
+
+		envClk, hostClk := clock.NewMock(), clock.NewMock()
+		env := inproc.NewEnv(inproc.WithLeases(ttl, envClk))
+
+		// The listener's clock is stopped, so it never renews.
+		l, err := inproc.Listen(env, ma, inproc.WithClock(hostClk))
+		require.NoError(t, err)
+		defer l.Close()
+
+		envClk.Add(2 * ttl)
+		_, err = inproc.Dial(context.Background(), env, ma)
+		require.ErrorIs(t, err, inproc.ErrRefused)
+		require.Len(t, env.Dangling(), 1)
+
+		hostClk.Add(ttl / 3)
+		_, err = l.Accept()
+		require.ErrorIs(t, err, net.ErrClosed, "should close listener that lost its lease")
+	})
+
+	t.Run("Gateway", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		env := inproc.NewEnv(inproc.WithLeases(ttl, clk))
+		gw := inproc.NewGateway(env, inproc.WithClock(clk))
+		defer gw.Close()
+
+		imported := multiaddr.StringCast("/inproc/imported")
+		require.NoError(t, gw.Import(imported, multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")))
+
+		require.Never(t, func() bool {
+			clk.Add(ttl / 3)
+
+			env.Lock()
+			defer env.Unlock()
+			_, ok := env.Lookup(imported)
+			return !ok
+		}, 100*time.Millisecond, time.Millisecond, "should renew imported bindings")
+		require.Empty(t, env.Dangling())
+	})
+}
@@ -0,0 +1,216 @@
+package inproc
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// maxRetransmits bounds the delay caused by a lossy link.
+const maxRetransmits = 16
+
+// Link describes the simulated network between two endpoints.  The
+// zero value is a perfect link, which delivers data immediately.
+//
+// Inproc streams are unbuffered, so a writer blocks while its data
+// is in transit.
+type Link struct {
+	// Latency delays the delivery of each chunk of data.
+	Latency time.Duration `json:"latency,omitempty"`
+
+	// Bandwidth limits the rate of delivery, in bytes per second.
+	// Zero means unlimited.
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+
+	// Loss is the probability that a chunk of data is lost in
+	// transit.  Streams are reliable, so a lost chunk is retransmitted
+	// after a round trip, as it would be over TCP.
+	Loss float64 `json:"loss,omitempty"`
+
+	// Down partitions the endpoints.  Dials are refused, and data
+	// written to existing conns is held until the link is restored.
+	Down bool `json:"down,omitempty"`
+}
+
+// Endpoint identifies one end of a link by address, by peer, or by
+// both.  Zero-valued fields match anything, so the zero Endpoint is a
+// wildcard.
+type Endpoint struct {
+	Addr multiaddr.Multiaddr
+	Peer peer.ID
+}
+
+func (e Endpoint) match(other Endpoint) bool {
+	return (e.Addr == nil || (other.Addr != nil && e.Addr.Equal(other.Addr))) &&
+		(e.Peer == "" || e.Peer == other.Peer)
+}
+
+// specificity ranks endpoints so that the most specific match wins.
+// Addresses are more specific than peers, since a peer may listen on
+// several addresses.
+func (e Endpoint) specificity() (n int) {
+	if e.Addr != nil {
+		n += 2
+	}
+	if e.Peer != "" {
+		n++
+	}
+	return
+}
+
+// LinkTable holds the properties of the links in an Env.  Links are
+// symmetric:  the link between a and b also applies between b and a.
+// The table may be changed at any time, and changes apply immediately
+// to existing conns.
+type LinkTable struct {
+	mu      sync.RWMutex
+	def     Link
+	links   []linkEntry
+	changed chan struct{} // closed and replaced when the table changes
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+type linkEntry struct {
+	a, b Endpoint
+	link Link
+}
+
+func newLinkTable() *LinkTable {
+	return &LinkTable{
+		changed: make(chan struct{}),
+		rand:    rand.New(rand.NewSource(0)),
+	}
+}
+
+// Seed sets the seed of the PRNG that simulates packet loss.
+func (lt *LinkTable) Seed(seed int64) {
+	lt.randMu.Lock()
+	defer lt.randMu.Unlock()
+
+	lt.rand = rand.New(rand.NewSource(seed))
+}
+
+// Default returns the properties of links that do not match any entry
+// in the table.
+func (lt *LinkTable) Default() Link {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+
+	return lt.def
+}
+
+// SetDefault sets the properties of links that do not match any entry
+// in the table.
+func (lt *LinkTable) SetDefault(l Link) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.def = l
+	lt.notify()
+}
+
+// Set the properties of the link between a and b, replacing any entry
+// for the same pair of endpoints.
+func (lt *LinkTable) Set(a, b Endpoint, l Link) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	defer lt.notify()
+
+	for i, e := range lt.links {
+		if e.same(a, b) {
+			lt.links[i].link = l
+			return
+		}
+	}
+
+	lt.links = append(lt.links, linkEntry{a: a, b: b, link: l})
+}
+
+// Remove the entry for the link between a and b, if any.
+func (lt *LinkTable) Remove(a, b Endpoint) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	for i, e := range lt.links {
+		if e.same(a, b) {
+			lt.links = append(lt.links[:i], lt.links[i+1:]...)
+			lt.notify()
+			return
+		}
+	}
+}
+
+// Lookup returns the properties of the link between a and b.  If
+// several entries match, the most specific one is used.  Ties are
+// resolved in favor of the entry that was added last.
+func (lt *LinkTable) Lookup(a, b Endpoint) Link {
+	link, _ := lt.lookup(a, b)
+	return link
+}
+
+func (lt *LinkTable) lookup(a, b Endpoint) (Link, <-chan struct{}) {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+
+	link, best := lt.def, -1
+	for _, e := range lt.links {
+		if !e.match(a, b) {
+			continue
+		}
+
+		if s := e.a.specificity() + e.b.specificity(); s >= best {
+			link, best = e.link, s
+		}
+	}
+
+	return link, lt.changed
+}
+
+// delay returns the time it takes n bytes to cross the link.
+func (lt *LinkTable) delay(l Link, n int) (d time.Duration) {
+	d = l.Latency
+	if l.Bandwidth > 0 {
+		d += time.Duration(int64(n) * int64(time.Second) / l.Bandwidth)
+	}
+
+	if l.Loss > 0 {
+		lt.randMu.Lock()
+		defer lt.randMu.Unlock()
+
+		for i := 0; i < maxRetransmits && lt.rand.Float64() < l.Loss; i++ {
+			d += 2 * l.Latency
+		}
+	}
+
+	return
+}
+
+// notify wakes writers that are waiting for a link to be restored.
+// The caller must hold lt.mu.
+func (lt *LinkTable) notify() {
+	close(lt.changed)
+	lt.changed = make(chan struct{})
+}
+
+func (e linkEntry) match(a, b Endpoint) bool {
+	return (e.a.match(a) && e.b.match(b)) || (e.a.match(b) && e.b.match(a))
+}
+
+func (e linkEntry) same(a, b Endpoint) bool {
+	return (sameEndpoint(e.a, a) && sameEndpoint(e.b, b)) ||
+		(sameEndpoint(e.a, b) && sameEndpoint(e.b, a))
+}
+
+func sameEndpoint(x, y Endpoint) bool {
+	if (x.Addr == nil) != (y.Addr == nil) {
+		return false
+	}
+
+	return x.Peer == y.Peer && (x.Addr == nil || x.Addr.Equal(y.Addr))
+}
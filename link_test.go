@@ -0,0 +1,105 @@
+package inproc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkTable(t *testing.T) {
+	t.Parallel()
+
+	var (
+		a    = Endpoint{Addr: multiaddr.StringCast("/inproc/a"), Peer: "A"}
+		b    = Endpoint{Addr: multiaddr.StringCast("/inproc/b"), Peer: "B"}
+		c    = Endpoint{Addr: multiaddr.StringCast("/inproc/c"), Peer: "C"}
+		slow = Link{Latency: time.Second}
+		fast = Link{Latency: time.Millisecond}
+		down = Link{Down: true}
+	)
+
+	lt := newLinkTable()
+	require.Equal(t, Link{}, lt.Lookup(a, b), "should default to perfect link")
+
+	lt.SetDefault(slow)
+	require.Equal(t, slow, lt.Lookup(a, b))
+
+	lt.Set(Endpoint{Peer: "A"}, Endpoint{}, fast)
+	require.Equal(t, fast, lt.Lookup(a, b), "wildcard should match")
+	require.Equal(t, fast, lt.Lookup(c, a), "links should be symmetric")
+	require.Equal(t, slow, lt.Lookup(b, c))
+
+	lt.Set(Endpoint{Addr: a.Addr}, Endpoint{Peer: "B"}, down)
+	require.Equal(t, down, lt.Lookup(b, a), "most specific entry should win")
+	require.Equal(t, fast, lt.Lookup(a, c))
+
+	lt.Set(Endpoint{Peer: "B"}, Endpoint{Addr: a.Addr}, fast)
+	require.Equal(t, fast, lt.Lookup(a, b), "should replace entry")
+
+	lt.Remove(Endpoint{Peer: "A"}, Endpoint{})
+	require.Equal(t, slow, lt.Lookup(a, c))
+}
+
+func TestLinkDelay(t *testing.T) {
+	t.Parallel()
+
+	lt := newLinkTable()
+	require.Equal(t, 1500*time.Millisecond,
+		lt.delay(Link{Latency: time.Second, Bandwidth: 1024}, 512))
+
+	lossy := func(seed int64) (ds []time.Duration) {
+		lt.Seed(seed)
+		for i := 0; i < 16; i++ {
+			ds = append(ds, lt.delay(Link{Latency: time.Millisecond, Loss: .5}, 1))
+		}
+		return
+	}
+
+	ds := lossy(1)
+	require.Equal(t, ds, lossy(1), "loss should be reproducible")
+	require.Contains(t, ds, time.Millisecond)
+	require.Contains(t, ds, 3*time.Millisecond, "should retransmit after a round trip")
+}
+
+func TestLinkTraverse(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	_, dc, _, lc := newConnTest(t, WithClock(clk))
+	s, a := openTestStream(t, dc, lc)
+	defer s.Reset()
+	defer a.Reset()
+
+	env := dc.l.t.env
+	env.Links().Set(dc.localEndpoint(), dc.remoteEndpoint(), Link{Latency: time.Second})
+
+	go s.Write([]byte("hello"))
+
+	// the reader drains the chunk one byte at a time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := make([]byte, 1)
+		for i := 0; i < len("hello"); i++ {
+			if _, err := a.Read(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := clk.Now()
+	require.Eventually(t, func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			clk.Add(100 * time.Millisecond)
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	require.Less(t, clk.Since(start), 2*time.Second,
+		"should charge latency once per chunk, not once per read")
+}
@@ -45,13 +45,20 @@ func (l listener) Accept() (transport.CapableConn, error) {
 }
 
 func (l listener) Close() error {
+	l.t.env.Lock()
+	defer l.t.env.Unlock()
+
 	select {
 	case <-l.cq:
 	default:
-		l.t.env.Lock()
 		close(l.cq)
-		l.t.env.Free(l.ma)
-		l.t.env.Unlock()
+		l.t.env.Release(l.ma, l.t)
+
+		l.t.mu.Lock()
+		if cur, ok := l.t.ls[l.ma.String()]; ok && cur.cq == l.cq {
+			delete(l.t.ls, l.ma.String())
+		}
+		l.t.mu.Unlock()
 	}
 	return nil
 }
@@ -63,22 +70,82 @@ func (l listener) Multiaddr() multiaddr.Multiaddr { return l.ma }
  * Used by Transport
  */
 
-func (l listener) NewConn(ctx context.Context, dialer *Transport) (*conn, error) {
-	d, err := dialer.dialback()
-	if err != nil {
-		return nil, err
+// NewConn connects the dialer's dialback listener d to the listener,
+// which is bound to raddr or to a pattern that matches it.
+func (l listener) NewConn(ctx context.Context, raddr multiaddr.Multiaddr, d *listener) (*conn, error) {
+	if l.t.env.Links().Lookup(
+		Endpoint{Addr: d.ma, Peer: d.t.id()},
+		Endpoint{Addr: raddr, Peer: l.t.id()}).Down {
+		return nil, ErrRefused
 	}
 
 	local, remote := l.newConnPair(d)
+	remote.ma = raddr
+
+	if l.t.env.Faults().accept(l.t.id(), d.t.id()) {
+		local.Close()
+		remote.Close()
+		return local, nil
+	}
+
+	label := fmt.Sprintf("%s->%s", d.Multiaddr(), raddr)
+	if !l.t.env.Scheduler().await(EventAccept, local.key, label, l.cq, ctx.Done()) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("closed")
+	}
+
+	// register the conns first, in case they are closed as soon as
+	// they are accepted
+	local.open()
+	remote.open()
+	l.t.env.Tracker().addConn(local, remote)
 
+	var err error
 	select {
 	case <-l.cq:
-		return nil, errors.New("closed")
+		err = errors.New("closed")
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		err = ctx.Err()
 	case l.accept <- remote:
+		l.t.track(remote.cq, local.cq)
 		return local, nil
 	}
+
+	local.Close()
+	remote.Close()
+	return nil, err
+}
+
+// acceptExternal delivers a conn that a Gateway accepted from the real
+// network.  Like the conns that NewConn delivers, it is subject to the
+// Env's links, faults and scheduler.  The caller closes c if acceptExternal
+// fails.
+func (l listener) acceptExternal(ctx context.Context, c transport.CapableConn, key source) error {
+	if l.t.env.Links().Lookup(
+		Endpoint{Addr: c.RemoteMultiaddr(), Peer: c.RemotePeer()},
+		Endpoint{Addr: l.ma, Peer: l.t.id()}).Down {
+		return ErrRefused
+	}
+
+	if l.t.env.Faults().accept(l.t.id(), c.RemotePeer()) {
+		return ErrRefused
+	}
+
+	label := fmt.Sprintf("%s->%s", c.RemoteMultiaddr(), l.ma)
+	if !l.t.env.Scheduler().await(EventAccept, key, label, l.cq, ctx.Done()) {
+		return errors.New("closed")
+	}
+
+	select {
+	case l.accept <- c:
+		return nil
+	case <-l.cq:
+		return errors.New("closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (t *Transport) dialback() (l *listener, err error) {
@@ -90,7 +157,11 @@ func (t *Transport) dialback() (l *listener, err error) {
 	laddr := newRandomAddr()
 	t.env.Bind(laddr, t) // caller already holds the lock
 
-	return t.newListener(laddr)
+	if l, err = t.newListener(laddr); err == nil {
+		t.renew(laddr, l.cq, l.Close)
+	}
+
+	return
 }
 
 func (t *Transport) getRandomListener() (l *listener) {
@@ -98,10 +169,12 @@ func (t *Transport) getRandomListener() (l *listener) {
 	defer t.mu.RUnlock()
 
 	for _, l = range t.ls {
-		break
+		if !IsPattern(l.ma) {
+			return
+		}
 	}
 
-	return
+	return nil
 }
 
 func newRandomAddr() multiaddr.Multiaddr {
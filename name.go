@@ -0,0 +1,83 @@
+package inproc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxNameLen is the maximum length of an inproc name.
+	MaxNameLen = 255
+
+	// MaxLabelLen is the maximum length of each dot-separated label
+	// in an inproc name.
+	MaxLabelLen = 63
+
+	// wildcard is the reserved name that Resolve expands to a free
+	// address.
+	wildcard = "~"
+)
+
+// ErrInvalidName is returned when an inproc name does not follow the
+// grammar described by ValidateName.
+var ErrInvalidName = errors.New("invalid inproc name")
+
+// ValidateName checks that s is a valid inproc name, i.e. the value in
+// "/inproc/<name>".  A name is either the reserved wildcard "~", or a
+// sequence of labels separated by dots, as in "cluster.shard-1.api".
+// Each label is between 1 and MaxLabelLen characters long, and the
+// name is at most MaxNameLen characters long.  Labels consist of
+// ASCII letters, digits, '-' and '_', and of the characters '*', '?',
+// '[', ']', '^' and '\', which form patterns (see IsPattern).
+//
+// Errors wrap ErrInvalidName, and describe the problem.
+func ValidateName(s string) error {
+	switch {
+	case s == wildcard:
+		return nil
+	case s == "":
+		return fmt.Errorf("%w: empty name", ErrInvalidName)
+	case len(s) > MaxNameLen:
+		return fmt.Errorf("%w %.32q...: longer than %d characters",
+			ErrInvalidName, s, MaxNameLen)
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if err := validateLabel(label); err != nil {
+			return fmt.Errorf("%w %q: %s", ErrInvalidName, s, err)
+		}
+	}
+
+	return nil
+}
+
+func validateLabel(label string) error {
+	switch {
+	case label == "":
+		return errors.New("empty label")
+	case len(label) > MaxLabelLen:
+		return fmt.Errorf("label %q is longer than %d characters", label, MaxLabelLen)
+	}
+
+	for i := 0; i < len(label); i++ {
+		if !isNameChar(label[i]) {
+			if label[i] == '~' {
+				return errors.New("'~' is reserved, and must be the entire name")
+			}
+			return fmt.Errorf("invalid character %q at offset %d of label %q",
+				label[i], i, label)
+		}
+	}
+
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+
+	return strings.IndexByte("-_*?[]^\\", c) >= 0
+}
@@ -0,0 +1,189 @@
+package inproc
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+var (
+	_ net.Listener = (*netListener)(nil)
+	_ net.Conn     = (*netConn)(nil)
+)
+
+// Listen announces on an inproc address or pattern in env, for use by code that
+// is not based on libp2p, such as HTTP or gRPC servers.  The listener
+// accepts conns from Dial, which behave like TCP conns, including
+// support for CloseRead and CloseWrite.  The Env's scheduler and fault
+// injector apply to these conns as they do to libp2p streams.
+//
+// Options other than WithEnv apply to the listener's end of each conn.
+func Listen(env Env, laddr multiaddr.Multiaddr, opt ...Option) (net.Listener, error) {
+	laddr, err := Resolve(laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = validatePattern(laddr); err != nil {
+		return nil, err
+	}
+
+	t := newTransport(nil, nil, append(opt, WithEnv(env)))
+
+	env.Lock()
+	defer env.Unlock()
+
+	if !env.Bind(laddr, t) {
+		return nil, ErrInUse
+	}
+
+	l := t.newNetListener(laddr)
+	t.renew(laddr, l.cq, l.Close)
+	return l, nil
+}
+
+// Dial connects to a net.Listener that was created by Listen.
+//
+// Options other than WithEnv apply to the dialer's end of the conn.
+func Dial(ctx context.Context, env Env, raddr multiaddr.Multiaddr, opt ...Option) (net.Conn, error) {
+	env.Lock()
+	bound, ok := env.Lookup(raddr)
+	env.Unlock()
+
+	if !ok {
+		return nil, ErrRefused
+	}
+
+	t := newTransport(nil, nil, append(opt, WithEnv(env)))
+	if err := t.env.Faults().dial("", ""); err != nil {
+		return nil, err
+	}
+
+	return bound.acceptNet(ctx, raddr, t)
+}
+
+func (t *Transport) newNetListener(laddr multiaddr.Multiaddr) *netListener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	na, _ := toInprocNetAddr(laddr)
+	l := &netListener{
+		t:      t,
+		ma:     laddr,
+		na:     na,
+		cq:     make(chan struct{}),
+		accept: make(chan net.Conn),
+	}
+	t.nls[laddr.String()] = l
+
+	return l
+}
+
+func (t *Transport) acceptNet(ctx context.Context, raddr multiaddr.Multiaddr, dialer *Transport) (net.Conn, error) {
+	t.mu.RLock()
+	l, ok := lookupAddr(t.nls, raddr)
+	t.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrRefused // bound by a libp2p transport
+	}
+
+	return l.newConn(ctx, raddr, dialer)
+}
+
+type netListener struct {
+	t *Transport
+
+	ma multiaddr.Multiaddr
+	na net.Addr
+
+	cq     chan struct{}
+	accept chan net.Conn
+}
+
+func (l *netListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.cq:
+		return nil, net.ErrClosed
+	case conn := <-l.accept:
+		return conn, nil
+	}
+}
+
+func (l *netListener) Close() error {
+	l.t.env.Lock()
+	defer l.t.env.Unlock()
+
+	select {
+	case <-l.cq:
+	default:
+		close(l.cq)
+		l.t.env.Release(l.ma, l.t)
+
+		l.t.mu.Lock()
+		if l.t.nls[l.ma.String()] == l {
+			delete(l.t.nls, l.ma.String())
+		}
+		l.t.mu.Unlock()
+	}
+	return nil
+}
+
+func (l *netListener) Addr() net.Addr { return l.na }
+
+func (l *netListener) newConn(ctx context.Context, raddr multiaddr.Multiaddr, dialer *Transport) (net.Conn, error) {
+	// Like an ephemeral port, the dialer's address is not bound.
+	na, _ := toInprocNetAddr(newRandomAddr())
+	ra, _ := toInprocNetAddr(raddr) // differs from l.na if l is bound to a pattern
+
+	local, remote := newPipe(dialer.clock, l.t.clock)
+	local.label = fmt.Sprintf("%s->%s", na, ra)
+	remote.label = fmt.Sprintf("%s<-%s", ra, na)
+	key := dialer.nextSource()
+	local.key, remote.key = append(key, 0), append(key, 1)
+	local.sched, remote.sched = l.t.env.Scheduler(), l.t.env.Scheduler()
+	local.faults, remote.faults = l.t.env.Faults(), l.t.env.Faults()
+	local.chunk, remote.chunk = dialer.chunk, l.t.chunk
+	local.linger, remote.linger = dialer.linger, l.t.linger
+
+	if !local.sched.await(EventAccept, local.key, local.label, l.cq, ctx.Done()) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrRefused
+	}
+
+	l.t.env.Tracker().addStream(local, remote)
+
+	var err error
+	select {
+	case <-l.cq:
+		err = ErrRefused
+	case <-ctx.Done():
+		err = ctx.Err()
+	case l.accept <- &netConn{pipe: remote, laddr: ra, raddr: na, acceptor: l.t}:
+		l.t.track(remote.localDone, local.localDone)
+		return &netConn{pipe: local, laddr: na, raddr: ra, acceptor: l.t}, nil
+	}
+
+	local.tracker.removeStream(local)
+	remote.tracker.removeStream(remote)
+	return nil, err
+}
+
+// netConn is a stream that is not part of a libp2p conn.
+type netConn struct {
+	*pipe
+	laddr, raddr net.Addr
+	acceptor     *Transport // that accepted the conn, and tracks it
+}
+
+func (c *netConn) Close() error {
+	defer c.acceptor.untrack()
+	return c.pipe.Close()
+}
+
+func (c *netConn) LocalAddr() net.Addr  { return c.laddr }
+func (c *netConn) RemoteAddr() net.Addr { return c.raddr }
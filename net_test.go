@@ -0,0 +1,127 @@
+package inproc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNet(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Echo", func(t *testing.T) {
+		t.Parallel()
+
+		env := inproc.NewEnv()
+		ma := multiaddr.StringCast("/inproc/echo")
+
+		l, err := inproc.Listen(env, ma)
+		require.NoError(t, err)
+		defer l.Close()
+
+		go func() {
+			conn, err := l.Accept()
+			if assert.NoError(t, err) {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}
+		}()
+
+		conn, err := inproc.Dial(context.Background(), env, ma)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.Equal(t, "inproc", conn.RemoteAddr().Network())
+		require.Equal(t, l.Addr(), conn.RemoteAddr())
+
+		_, err = io.WriteString(conn, "hello")
+		require.NoError(t, err)
+		require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+
+		b, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b))
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		t.Parallel()
+
+		env := inproc.NewEnv()
+		ma := multiaddr.StringCast("/inproc/http")
+
+		l, err := inproc.Listen(env, ma)
+		require.NoError(t, err)
+
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello, world!")
+		})}
+		go srv.Serve(l)
+		defer srv.Close()
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return inproc.Dial(ctx, env, ma)
+			},
+		}}
+
+		res, err := client.Get("http://inproc/")
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, "hello, world!", string(b))
+	})
+
+	t.Run("Pattern", func(t *testing.T) {
+		t.Parallel()
+
+		env := inproc.NewEnv()
+		l, err := inproc.Listen(env, multiaddr.StringCast("/inproc/service-*"))
+		require.NoError(t, err)
+		defer l.Close()
+
+		ma := multiaddr.StringCast("/inproc/service-1")
+		go func() {
+			conn, err := l.Accept()
+			if assert.NoError(t, err) {
+				defer conn.Close()
+				io.WriteString(conn, conn.LocalAddr().String())
+			}
+		}()
+
+		conn, err := inproc.Dial(context.Background(), env, ma)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		b, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, "/service-1", string(b), "should accept on dialed address")
+		require.Equal(t, "/service-1", conn.RemoteAddr().String())
+
+		_, err = inproc.Listen(env, multiaddr.StringCast("/inproc/service-["))
+		require.Error(t, err, "should reject malformed pattern")
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		t.Parallel()
+
+		env := inproc.NewEnv()
+		h, err := newTestHost(env)
+		require.NoError(t, err)
+		defer h.Close()
+
+		_, err = inproc.Dial(context.Background(), env, h.Addrs()[0])
+		require.ErrorIs(t, err, inproc.ErrRefused, "should not dial libp2p listener")
+
+		_, err = inproc.Dial(context.Background(), env, multiaddr.StringCast("/inproc/nobody"))
+		require.ErrorIs(t, err, inproc.ErrRefused)
+	})
+}
@@ -0,0 +1,274 @@
+package inproc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// EventKind identifies the type of a delivery event.
+type EventKind uint8
+
+const (
+	// EventAccept is the delivery of a new conn to a listener.
+	EventAccept EventKind = iota
+	// EventOpen is the delivery of a new stream to the remote conn.
+	EventOpen
+	// EventData is the delivery of a chunk of data to the remote reader.
+	EventData
+	// EventClose is the delivery of a Close, CloseRead or CloseWrite.
+	EventClose
+	// EventReset is the delivery of a stream reset.
+	EventReset
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventAccept:
+		return "accept"
+	case EventOpen:
+		return "open"
+	case EventData:
+		return "data"
+	case EventClose:
+		return "close"
+	case EventReset:
+		return "reset"
+	}
+
+	return fmt.Sprintf("EventKind(%d)", k)
+}
+
+// Event is a delivery that is waiting to be released by a Scheduler.
+type Event struct {
+	Kind  EventKind
+	Label string // identifies the conn or stream end that emitted the event
+
+	key     source
+	seq     uint64
+	release chan struct{}
+}
+
+func (e Event) String() string { return fmt.Sprintf("%s %s", e.Kind, e.Label) }
+
+// Scheduler holds back every delivery event in an Env until it is
+// released, either explicitly through Step or automatically through
+// Run.  The next event is chosen by a PRNG seeded with a fixed value,
+// from among the pending events sorted by their source, so that a
+// given seed reproduces the same interleaving whenever the same events
+// are pending.  An event's source is derived from the order in which
+// the Env's transports were created, dialed their conns and opened
+// their streams, rather than from their addresses, which may be
+// random.
+//
+// A nil *Scheduler releases all events immediately.
+type Scheduler struct {
+	seed int64
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	seq     uint64
+	sources uint64
+	pending []*Event
+	signal  chan struct{} // closed and replaced when an event is queued
+}
+
+// NewScheduler returns a scheduler whose choices are determined by
+// seed.
+func NewScheduler(seed int64) *Scheduler {
+	return &Scheduler{
+		seed:   seed,
+		rand:   rand.New(rand.NewSource(seed)),
+		signal: make(chan struct{}),
+	}
+}
+
+// Seed returns the value with which the scheduler was seeded.  Log it
+// from failing tests so that the interleaving can be replayed.
+func (s *Scheduler) Seed() int64 { return s.seed }
+
+// Pending returns the events that are waiting to be released, in the
+// order from which Step chooses.
+func (s *Scheduler) Pending() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sort()
+
+	evs := make([]Event, len(s.pending))
+	for i, ev := range s.pending {
+		evs[i] = *ev
+	}
+
+	return evs
+}
+
+// Step releases a single pending event.  It returns false if no
+// events were pending.
+func (s *Scheduler) Step() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return false
+	}
+
+	s.sort()
+	i := s.rand.Intn(len(s.pending))
+	ev := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	close(ev.release)
+
+	return true
+}
+
+// Wait blocks until at least n events are pending, or until the
+// context expires.
+func (s *Scheduler) Wait(ctx context.Context, n int) error {
+	for {
+		s.mu.Lock()
+		ready, signal := len(s.pending) >= n, s.signal
+		s.mu.Unlock()
+
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-signal:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Run releases events as they are queued, until the context expires.
+// Events that are pending at the same time are released in seeded
+// order, but which events are pending at the same time depends on the
+// Go scheduler.  Use Wait and Step to control interleavings exactly.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		if err := s.Wait(ctx, 1); err != nil {
+			return err
+		}
+
+		s.Step()
+	}
+}
+
+// await queues an event and blocks until it is released.  It returns
+// false without waiting further if any of the abort channels is closed
+// first.
+func (s *Scheduler) await(kind EventKind, key source, label string, abort ...<-chan struct{}) bool {
+	if s == nil {
+		return true
+	}
+
+	ev := s.push(kind, key, label)
+
+	cases := make([]reflect.SelectCase, 0, len(abort)+1)
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ev.release),
+	})
+	for _, ch := range abort {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ch),
+		})
+	}
+
+	if i, _, _ := reflect.Select(cases); i != 0 {
+		s.remove(ev)
+		return false
+	}
+
+	return true
+}
+
+func (s *Scheduler) push(kind EventKind, key source, label string) *Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	ev := &Event{
+		Kind:    kind,
+		Label:   label,
+		key:     key,
+		seq:     s.seq,
+		release: make(chan struct{}),
+	}
+	s.pending = append(s.pending, ev)
+
+	close(s.signal)
+	s.signal = make(chan struct{})
+
+	return ev
+}
+
+func (s *Scheduler) remove(ev *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.pending {
+		if e == ev {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// sort orders pending events independently of their arrival order,
+// which depends on the Go scheduler.  Arrival order is only used to
+// break ties.  The caller must hold s.mu.
+func (s *Scheduler) sort() {
+	sort.Slice(s.pending, func(i, j int) bool {
+		a, b := s.pending[i], s.pending[j]
+		if c := a.key.compare(b.key); c != 0 {
+			return c < 0
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.seq < b.seq
+	})
+}
+
+// newSource returns the next of the sequence numbers that the
+// scheduler assigns to transports, in the order in which they are
+// created.  It returns zero if s is nil.
+func (s *Scheduler) newSource() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sources++
+	return s.sources
+}
+
+// source identifies the conn or stream end that emitted an event, by
+// a path of sequence numbers: the transport that dialed the conn, the
+// conn among those that the transport dialed, and the stream's ID and
+// end.  Unlike labels, sources are the same in every run of a test
+// that creates its transports, conns and streams in the same order.
+type source []uint64
+
+// compare orders sources lexicographically.
+func (s source) compare(other source) int {
+	for i := 0; i < len(s) && i < len(other); i++ {
+		if s[i] != other[i] {
+			if s[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return len(s) - len(other)
+}
@@ -0,0 +1,252 @@
+package inproc
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		require.False(t, NewScheduler(0).Step(), "should not release anything")
+	})
+
+	t.Run("Reproducible", func(t *testing.T) {
+		t.Parallel()
+
+		for seed := int64(0); seed < 8; seed++ {
+			require.Equal(t,
+				interleave(t, seed),
+				interleave(t, seed),
+				"seed %d produced different interleavings", seed)
+		}
+	})
+
+	t.Run("RandomAddrs", func(t *testing.T) {
+		t.Parallel()
+
+		// the conns' dialback addresses differ in every run
+		for seed := int64(0); seed < 4; seed++ {
+			require.Equal(t,
+				interleaveConns(t, seed),
+				interleaveConns(t, seed),
+				"seed %d produced different interleavings", seed)
+		}
+	})
+
+	t.Run("PendingAccept", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		s := NewScheduler(0)
+		opt := []Option{WithEnv(NewEnv(WithScheduler(s)))}
+		lt := newTransport(nil, newTestKey(t), opt)
+		dt := newTransport(nil, newTestKey(t), opt)
+
+		l, err := lt.Listen(multiaddr.StringCast("/inproc/~"))
+		require.NoError(t, err)
+		defer l.Close()
+
+		go l.Accept()
+
+		dialed := make(chan error, 1)
+		go func() {
+			c, err := dt.Dial(ctx, l.Multiaddr(), lt.id())
+			if err == nil {
+				c.Close()
+			}
+			dialed <- err
+		}()
+		require.NoError(t, s.Wait(ctx, 1))
+		require.Equal(t, EventAccept, s.Pending()[0].Kind)
+
+		// the pending accept should not hold the Env's lock
+		listened := make(chan error, 1)
+		go func() {
+			l, err := newTransport(nil, newTestKey(t), opt).Listen(multiaddr.StringCast("/inproc/~"))
+			if err == nil {
+				l.Close()
+			}
+			listened <- err
+		}()
+		select {
+		case err := <-listened:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("should bind while an accept is pending")
+		}
+
+		require.True(t, s.Step())
+		require.NoError(t, <-dialed)
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		t.Parallel()
+
+		s := NewScheduler(0)
+		local, _ := newScheduledPipe(s, "abort")
+
+		cherr := make(chan error, 1)
+		go func() {
+			_, err := local.Write([]byte("hello"))
+			cherr <- err
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, s.Wait(ctx, 1))
+		require.Equal(t, EventData, s.Pending()[0].Kind)
+
+		// an expired deadline aborts the pending write
+		require.NoError(t, local.SetWriteDeadline(time.Unix(0, 0)))
+		require.ErrorIs(t, <-cherr, os.ErrDeadlineExceeded)
+		require.Empty(t, s.Pending(), "aborted events should be dequeued")
+	})
+}
+
+// interleave writes on several scheduled streams concurrently, and
+// returns the order in which the writes were delivered.
+func interleave(t *testing.T, seed int64) (order []string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	labels := []string{"a", "b", "c", "d"}
+	s := NewScheduler(seed)
+	readers := make(map[string]*pipe)
+	for _, label := range labels {
+		local, remote := newScheduledPipe(s, label)
+		readers[label] = remote
+		go local.Write([]byte(label))
+	}
+
+	require.NoError(t, s.Wait(ctx, len(labels)))
+
+	for range labels {
+		ev := s.Pending()
+		require.True(t, s.Step())
+
+		// exactly one of the pending writes was released; find it
+		for _, e := range ev {
+			if !contains(s.Pending(), e) {
+				b := make([]byte, 1)
+				_, err := readers[e.Label].Read(b)
+				require.NoError(t, err)
+				order = append(order, string(b))
+			}
+		}
+	}
+
+	return
+}
+
+// interleaveConns is like interleave, but writes on streams over conns
+// that several transports dialed from random addresses.
+func interleaveConns(t *testing.T, seed int64) (order []string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s := NewScheduler(seed)
+	opt := []Option{WithEnv(NewEnv(WithScheduler(s)))}
+
+	// release the resets when the conns are closed
+	teardown, stopTeardown := context.WithCancel(context.Background())
+	t.Cleanup(stopTeardown)
+	defer func() { go s.Run(teardown) }()
+
+	lt := newTransport(nil, newTestKey(t), opt)
+
+	l, err := lt.Listen(multiaddr.StringCast("/inproc/~"))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	// release the accepts and opens as they are queued
+	setup, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(setup)
+	}()
+
+	labels := []string{"a", "b", "c", "d"}
+	readers := make(map[string]network.MuxedStream)
+	writers := make(map[string]network.MuxedStream)
+	for _, label := range labels {
+		accepted := make(chan transport.CapableConn, 1)
+		go func() {
+			c, _ := l.Accept()
+			accepted <- c
+		}()
+
+		dt := newTransport(nil, newTestKey(t), opt)
+		c, err := dt.Dial(ctx, l.Multiaddr(), lt.id())
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+
+		lc := <-accepted
+		require.NotNil(t, lc)
+		t.Cleanup(func() { lc.Close() })
+
+		w, r := openTestStream(t, c.(*conn), lc.(*conn))
+		writers[label], readers[w.(*pipe).label] = w, r
+	}
+
+	stop()
+	<-done
+
+	for label, w := range writers {
+		go w.Write([]byte(label))
+	}
+	require.NoError(t, s.Wait(ctx, len(labels)))
+
+	for range labels {
+		ev := s.Pending()
+		require.True(t, s.Step())
+
+		for _, e := range ev {
+			if !contains(s.Pending(), e) {
+				b := make([]byte, 1)
+				_, err := readers[e.Label].Read(b)
+				require.NoError(t, err)
+				order = append(order, string(b))
+			}
+		}
+	}
+
+	return
+}
+
+func newScheduledPipe(s *Scheduler, label string) (*pipe, *pipe) {
+	clk := clock.New()
+	local, remote := newPipe(clk, clk)
+	local.label, remote.label = label, label
+	local.key = source{s.newSource(), 0}
+	remote.key = source{local.key[0], 1}
+	local.sched, remote.sched = s, s
+	return local, remote
+}
+
+func contains(evs []Event, ev Event) bool {
+	for _, e := range evs {
+		if e.seq == ev.seq {
+			return true
+		}
+	}
+	return false
+}
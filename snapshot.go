@@ -0,0 +1,257 @@
+package inproc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Snapshot is a serializable description of the state of an Env, for
+// debugging hung simulations.  Its link configuration can be imported
+// into another Env to reproduce the conditions of a run.
+type Snapshot struct {
+	Peers []PeerSnapshot `json:"peers"`
+	Links LinkSnapshot   `json:"links"`
+}
+
+// PeerSnapshot describes a transport that is bound in the Env.
+// Listeners counts the open libp2p and net listeners.  Conns lists
+// only libp2p conns; the net.Conns of Listen and Dial are omitted.
+type PeerSnapshot struct {
+	Peer      peer.ID        `json:"peer,omitempty"` // empty if the transport has no host
+	Addrs     []string       `json:"addrs"`
+	Listeners int            `json:"listeners"`
+	Conns     []ConnSnapshot `json:"conns,omitempty"`
+}
+
+// ConnSnapshot describes an open conn, from the point of view of one
+// of its ends.
+type ConnSnapshot struct {
+	LocalAddr  string           `json:"local_addr"`
+	RemoteAddr string           `json:"remote_addr"`
+	RemotePeer peer.ID          `json:"remote_peer,omitempty"`
+	Bytes      int64            `json:"bytes"` // carried by the open streams
+	Streams    []StreamSnapshot `json:"streams,omitempty"`
+}
+
+// StreamSnapshot describes a stream that is open at one end of a conn.
+type StreamSnapshot struct {
+	ID        uint64        `json:"id"` // odd if opened by the dialer
+	Label     string        `json:"label"`
+	Direction string        `json:"direction"` // "Inbound" or "Outbound"
+	State     string        `json:"state"`
+	Age       time.Duration `json:"age"`
+	Protocol  protocol.ID   `json:"protocol,omitempty"` // empty until negotiated
+	Bytes     int64         `json:"bytes"`
+}
+
+// Stream states, in the terms of HTTP/2.  Streams are removed from
+// snapshots when they are closed or reset at the end that reports
+// them, so a stream is only reported as closed if both ends called
+// CloseWrite, or as reset if the remote end reset it.
+const (
+	StreamOpen             = "open"
+	StreamHalfClosedLocal  = "half-closed (local)"
+	StreamHalfClosedRemote = "half-closed (remote)"
+	StreamClosed           = "closed"
+	StreamReset            = "reset"
+)
+
+// LinkSnapshot is the configuration of a LinkTable.
+type LinkSnapshot struct {
+	Default Link            `json:"default"`
+	Entries []LinkEntryJSON `json:"entries,omitempty"`
+}
+
+// LinkEntryJSON is an entry of a LinkSnapshot.
+type LinkEntryJSON struct {
+	A    EndpointJSON `json:"a"`
+	B    EndpointJSON `json:"b"`
+	Link Link         `json:"link"`
+}
+
+// EndpointJSON is the serializable form of an Endpoint.
+type EndpointJSON struct {
+	Addr string  `json:"addr,omitempty"`
+	Peer peer.ID `json:"peer,omitempty"`
+}
+
+func (env *mapEnv) Snapshot() Snapshot {
+	env.Lock()
+	env.expire()
+
+	addrs := make(map[*Transport][]string)
+	for _, rec := range env.bs {
+		for _, t := range rec.Ts {
+			addrs[t] = append(addrs[t], rec.Addr.String())
+		}
+	}
+	env.Unlock()
+
+	return snapshot(addrs, env.links)
+}
+
+// snapshot describes the transports, which are bound to addrs, and
+// the links between them.
+func snapshot(addrs map[*Transport][]string, links *LinkTable) Snapshot {
+	s := Snapshot{
+		Peers: make([]PeerSnapshot, 0, len(addrs)),
+		Links: links.Export(),
+	}
+	for t, as := range addrs {
+		sort.Strings(as)
+		s.Peers = append(s.Peers, t.snapshot(as))
+	}
+	sort.Slice(s.Peers, func(i, j int) bool {
+		return s.Peers[i].Addrs[0] < s.Peers[j].Addrs[0]
+	})
+
+	return s
+}
+
+func (t *Transport) snapshot(addrs []string) PeerSnapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ps := PeerSnapshot{
+		Peer:      t.id(),
+		Addrs:     addrs,
+		Listeners: len(t.ls) + len(t.nls),
+	}
+	for c := range t.cs {
+		ps.Conns = append(ps.Conns, c.snapshot())
+	}
+	sort.Slice(ps.Conns, func(i, j int) bool {
+		if ps.Conns[i].LocalAddr != ps.Conns[j].LocalAddr {
+			return ps.Conns[i].LocalAddr < ps.Conns[j].LocalAddr
+		}
+		return ps.Conns[i].RemoteAddr < ps.Conns[j].RemoteAddr
+	})
+
+	return ps
+}
+
+func (c *conn) snapshot() ConnSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cs := ConnSnapshot{
+		LocalAddr:  c.LocalMultiaddr().String(),
+		RemoteAddr: c.RemoteMultiaddr().String(),
+		RemotePeer: c.remote.l.t.id(),
+	}
+	for p := range c.streams {
+		ss := p.snapshot()
+		cs.Bytes += ss.Bytes
+		cs.Streams = append(cs.Streams, ss)
+	}
+	sort.Slice(cs.Streams, func(i, j int) bool {
+		return cs.Streams[i].ID < cs.Streams[j].ID
+	})
+
+	return cs
+}
+
+func (p *pipe) snapshot() StreamSnapshot {
+	dir := network.DirOutbound
+	if p.inbound {
+		dir = network.DirInbound
+	}
+
+	return StreamSnapshot{
+		ID:        p.id,
+		Label:     p.label,
+		Direction: dir.String(),
+		State:     p.state(),
+		Age:       p.readDeadline.clock.Since(p.opened),
+		Protocol:  p.info.Protocol(),
+		Bytes:     p.info.Bytes(),
+	}
+}
+
+// state reports which directions of the stream are closed.
+func (p *pipe) state() string {
+	local, remote := isClosedChan(p.localWriteDone), isClosedChan(p.remoteWriteDone)
+	switch {
+	case p.reset():
+		return StreamReset
+	case local && remote:
+		return StreamClosed
+	case local:
+		return StreamHalfClosedLocal
+	case remote:
+		return StreamHalfClosedRemote
+	}
+
+	return StreamOpen
+}
+
+// Export the configuration of the table.
+func (lt *LinkTable) Export() LinkSnapshot {
+	lt.mu.RLock()
+	defer lt.mu.RUnlock()
+
+	s := LinkSnapshot{Default: lt.def}
+	for _, e := range lt.links {
+		s.Entries = append(s.Entries, LinkEntryJSON{
+			A:    e.a.json(),
+			B:    e.b.json(),
+			Link: e.link,
+		})
+	}
+
+	return s
+}
+
+// Import replaces the configuration of the table with s, which was
+// typically exported from another Env.
+func (lt *LinkTable) Import(s LinkSnapshot) error {
+	links := make([]linkEntry, 0, len(s.Entries))
+	for _, e := range s.Entries {
+		a, err := e.A.endpoint()
+		if err != nil {
+			return err
+		}
+
+		b, err := e.B.endpoint()
+		if err != nil {
+			return err
+		}
+
+		links = append(links, linkEntry{a: a, b: b, link: e.Link})
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.def, lt.links = s.Default, links
+	lt.notify()
+
+	return nil
+}
+
+func (e Endpoint) json() EndpointJSON {
+	ej := EndpointJSON{Peer: e.Peer}
+	if e.Addr != nil {
+		ej.Addr = e.Addr.String()
+	}
+
+	return ej
+}
+
+func (ej EndpointJSON) endpoint() (Endpoint, error) {
+	e := Endpoint{Peer: ej.Peer}
+	if ej.Addr != "" {
+		ma, err := multiaddr.NewMultiaddr(ej.Addr)
+		if err != nil {
+			return Endpoint{}, err
+		}
+		e.Addr = ma
+	}
+
+	return e, nil
+}
@@ -0,0 +1,111 @@
+package inproc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p/core/host"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	env := inproc.NewEnv()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h0, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h0.Close()
+
+	h1, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	env.Links().Set(
+		inproc.Endpoint{Peer: h0.ID()},
+		inproc.Endpoint{Addr: multiaddr.StringCast("/inproc/elsewhere")},
+		inproc.Link{Latency: time.Millisecond, Loss: 0.1})
+
+	h0.SetStreamHandler("/test/snapshot", func(s network.Stream) {
+		defer s.Close()
+		io.WriteString(s, "ok")
+		<-ctx.Done()
+	})
+
+	require.NoError(t, h1.Connect(ctx, *host.InfoFromHost(h0)))
+	s, err := h1.NewStream(ctx, h0.ID(), "/test/snapshot")
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = io.ReadFull(s, make([]byte, 2))
+	require.NoError(t, err)
+
+	b, err := json.Marshal(env.Snapshot())
+	require.NoError(t, err)
+
+	var snap inproc.Snapshot
+	require.NoError(t, json.Unmarshal(b, &snap))
+
+	t.Run("Peers", func(t *testing.T) {
+		var found bool
+		for _, p := range snap.Peers {
+			if p.Peer != h0.ID() {
+				continue
+			}
+
+			require.Equal(t, 1, p.Listeners)
+			require.Len(t, p.Conns, 1)
+			require.Equal(t, h1.ID(), p.Conns[0].RemotePeer)
+			require.NotZero(t, p.Conns[0].Bytes, "should aggregate stream bytes")
+
+			for _, st := range p.Conns[0].Streams {
+				if st.Protocol == "/test/snapshot" {
+					found = true
+					require.NotZero(t, st.Bytes)
+					require.Equal(t, "Inbound", st.Direction)
+					require.Equal(t, uint64(1), st.ID%2, "dialer's streams should have odd IDs")
+					require.Equal(t, inproc.StreamOpen, st.State)
+					require.Positive(t, st.Age)
+				}
+			}
+		}
+		require.True(t, found, "should report stream protocol: %s", b)
+	})
+
+	t.Run("StreamIDs", func(t *testing.T) {
+		for _, p := range snap.Peers {
+			for _, c := range p.Conns {
+				for _, st := range c.Streams {
+					opener := p.Peer
+					if st.Direction == "Inbound" {
+						opener = c.RemotePeer
+					}
+
+					// h1 dialed h0
+					require.Equal(t, opener == h1.ID(), st.ID%2 == 1,
+						"stream %s has the wrong parity", st.Label)
+				}
+			}
+		}
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		other := inproc.NewEnv()
+		require.NoError(t, other.Links().Import(snap.Links))
+		require.Equal(t, env.Links().Export(), other.Links().Export())
+
+		link := other.Links().Lookup(
+			inproc.Endpoint{Addr: multiaddr.StringCast("/inproc/elsewhere")},
+			inproc.Endpoint{Peer: h0.ID()})
+		require.Equal(t, time.Millisecond, link.Latency)
+	})
+}
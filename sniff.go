@@ -0,0 +1,108 @@
+package inproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mikelsr/go-libp2p/core/protocol"
+)
+
+const (
+	multistreamID = "/multistream/1.0.0"
+
+	// maxSniff bounds the amount of data buffered while looking for
+	// the negotiated protocol.
+	maxSniff = 1024
+)
+
+// streamInfo is shared by both ends of a stream.
+type streamInfo struct {
+	bytes int64 // atomic; total bytes delivered in both directions
+
+	mu       sync.Mutex
+	proto    protocol.ID
+	sniffed  bool
+	sniffBuf []byte
+}
+
+func (s *streamInfo) Protocol() protocol.ID {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.proto
+}
+
+func (s *streamInfo) Bytes() int64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&s.bytes)
+}
+
+func (s *streamInfo) delivered(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.bytes, int64(n))
+	}
+}
+
+// sniff looks for the protocol that the responder accepts during
+// multistream-select negotiation.  The transport never sees protocol
+// IDs directly, since they are negotiated in-band, above the muxer.
+// Data written by the responder must be passed to sniff in order.
+func (s *streamInfo) sniff(b []byte) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sniffed {
+		return
+	}
+
+	s.sniffBuf = append(s.sniffBuf, b...)
+	for {
+		size, n := binary.Uvarint(s.sniffBuf)
+		if n == 0 {
+			return // need more data
+		}
+
+		if n < 0 || size > maxSniff {
+			s.stopSniffing() // not multistream-select
+			return
+		}
+
+		if uint64(len(s.sniffBuf)-n) < size {
+			if len(s.sniffBuf) > maxSniff {
+				s.stopSniffing()
+			}
+			return // need more data
+		}
+
+		msg := string(bytes.TrimSuffix(s.sniffBuf[n:n+int(size)], []byte("\n")))
+		s.sniffBuf = s.sniffBuf[n+int(size):]
+
+		switch {
+		case msg == multistreamID, msg == "na":
+			continue
+		case len(msg) > 0 && msg[0] == '/':
+			s.proto = protocol.ID(msg)
+		}
+
+		s.stopSniffing()
+		return
+	}
+}
+
+func (s *streamInfo) stopSniffing() {
+	s.sniffed = true
+	s.sniffBuf = nil
+}
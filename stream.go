@@ -1,24 +1,29 @@
 package inproc
 
 import (
+	"errors"
 	"io"
 	"net"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
 )
 
 // pipeDeadline is an abstraction for handling timeouts.
 type pipeDeadline struct {
+	clock clock.Clock
+
 	mu     sync.Mutex // Guards timer and cancel
-	timer  *time.Timer
+	timer  *clock.Timer
 	cancel chan struct{} // Must be non-nil
 }
 
-func makePipeDeadline() pipeDeadline {
-	return pipeDeadline{cancel: make(chan struct{})}
+func makePipeDeadline(clk clock.Clock) pipeDeadline {
+	return pipeDeadline{clock: clk, cancel: make(chan struct{})}
 }
 
 // set sets the point in time when the deadline will time out.
@@ -46,11 +51,11 @@ func (d *pipeDeadline) set(t time.Time) {
 	}
 
 	// Time in the future, setup a timer to cancel in the future.
-	if dur := time.Until(t); dur > 0 {
+	if dur := d.clock.Until(t); dur > 0 {
 		if closed {
 			d.cancel = make(chan struct{})
 		}
-		d.timer = time.AfterFunc(dur, func() {
+		d.timer = d.clock.AfterFunc(dur, func() {
 			close(d.cancel)
 		})
 		return
@@ -69,6 +74,13 @@ func (d *pipeDeadline) wait() chan struct{} {
 	return d.cancel
 }
 
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func isClosedChan(cs ...<-chan struct{}) bool {
 	for _, c := range cs {
 		select {
@@ -100,9 +112,35 @@ type pipe struct {
 
 	readDeadline  pipeDeadline
 	writeDeadline pipeDeadline
+
+	label string     // identifies this end of the stream to the scheduler
+	key   source     // orders this end's events for the scheduler
+	sched *Scheduler // releases deliveries; nil if unscheduled
+
+	conn    *conn          // nil if the pipe is not part of a conn
+	id      uint64         // shared by both ends; zero if conn is nil
+	inbound bool           // opened by the remote end of conn
+	opened  time.Time      // as measured by the local clock
+	info    *streamInfo    // shared by both ends
+	sniff   bool           // writes carry the responder's side of protocol negotiation
+	faults  *FaultInjector // nil if faults are not injected
+	chunk   Chunker        // nil if writes are delivered whole
+
+	written   int64 // bytes written locally; guarded by wrMu
+	truncated bool  // guarded by wrMu
+	discarded int   // bytes discarded after the remote closed for reading; guarded by wrMu
+
+	linger  time.Duration // how long Close waits for an in-progress write
+	flushMu sync.Mutex
+	writing chan struct{} // closed when the in-progress write returns; nil if none; guarded by flushMu
+
+	tracker              *Tracker // nil if the pipe is not tracked
+	rdBlocked, wrBlocked int64    // atomic; UnixNano when a read or write began, or zero
 }
 
-func newPipe() (*pipe, *pipe) {
+// newPipe returns both ends of a stream.  Each end's deadlines are
+// measured against its own clock.
+func newPipe(c1, c2 clock.Clock) (*pipe, *pipe) {
 	cb1 := make(chan []byte)
 	cb2 := make(chan []byte)
 	cn1 := make(chan int)
@@ -115,6 +153,7 @@ func newPipe() (*pipe, *pipe) {
 	wdone2 := make(chan struct{})
 	reset1 := make(chan struct{})
 	reset2 := make(chan struct{})
+	info := new(streamInfo)
 
 	p1 := &pipe{
 		rdRx: cb1, rdTx: cn1,
@@ -123,8 +162,9 @@ func newPipe() (*pipe, *pipe) {
 		localReadDone: rdone1, remoteReadDone: rdone2,
 		localWriteDone: wdone1, remoteWriteDone: wdone2,
 		localReset: reset1, remoteReset: reset2,
-		readDeadline:  makePipeDeadline(),
-		writeDeadline: makePipeDeadline(),
+		readDeadline:  makePipeDeadline(c1),
+		writeDeadline: makePipeDeadline(c1),
+		info:          info,
 	}
 	p2 := &pipe{
 		rdRx: cb2, rdTx: cn2,
@@ -133,13 +173,16 @@ func newPipe() (*pipe, *pipe) {
 		localReadDone: rdone2, remoteReadDone: rdone1,
 		localWriteDone: wdone2, remoteWriteDone: wdone1,
 		localReset: reset2, remoteReset: reset1,
-		readDeadline:  makePipeDeadline(),
-		writeDeadline: makePipeDeadline(),
+		readDeadline:  makePipeDeadline(c2),
+		writeDeadline: makePipeDeadline(c2),
+		info:          info,
 	}
 	return p1, p2
 }
 
 func (p *pipe) Read(b []byte) (int, error) {
+	defer p.block(&p.rdBlocked)()
+
 	n, err := p.read(b)
 	if err != nil && err != io.EOF && err != io.ErrClosedPipe {
 		err = &net.OpError{Op: "read", Net: "pipe", Err: err}
@@ -148,38 +191,50 @@ func (p *pipe) Read(b []byte) (int, error) {
 }
 
 func (p *pipe) read(b []byte) (n int, err error) {
+	for {
+		if err = p.readErr(); err != nil {
+			return 0, err
+		}
+
+		select {
+		case bw := <-p.rdRx:
+			nr := copy(b, bw)
+			p.rdTx <- nr
+			return nr, nil
+		case <-p.localDone:
+		case <-p.localReadDone:
+		case <-p.remoteDone:
+		case <-p.remoteWriteDone:
+		case <-p.localReset:
+		case <-p.remoteReset:
+		case <-p.readDeadline.wait():
+		}
+	}
+}
+
+// readErr reports why the pipe can no longer be read from, or nil if
+// it can.  The order of the cases follows yamux: a stream that was
+// closed for reading fails with ErrReset, and a reset does not undo
+// an EOF that the remote writer has already sent.  Close implies
+// CloseRead, except on a stream that was already reset.
+func (p *pipe) readErr() error {
 	switch {
-	case isClosedChan(p.localDone):
-		return 0, io.ErrClosedPipe
-	case isClosedChan(p.localReadDone, p.localReset, p.remoteReset):
-		return 0, network.ErrReset
-	case isClosedChan(p.remoteDone, p.remoteWriteDone):
-		return 0, io.EOF
+	case isClosedChan(p.localReadDone):
+		return network.ErrReset
+	case isClosedChan(p.remoteWriteDone):
+		return io.EOF
+	case isClosedChan(p.localReset, p.remoteReset, p.localDone):
+		return network.ErrReset
 	case isClosedChan(p.readDeadline.wait()):
-		return 0, os.ErrDeadlineExceeded
+		return os.ErrDeadlineExceeded
 	}
 
-	select {
-	case bw := <-p.rdRx:
-		nr := copy(b, bw)
-		p.rdTx <- nr
-		return nr, nil
-	case <-p.localDone:
-		return 0, io.ErrClosedPipe
-	case <-p.remoteDone:
-		return 0, io.EOF
-	case <-p.remoteWriteDone:
-		return 0, io.EOF
-	case <-p.localReset:
-		return 0, network.ErrReset
-	case <-p.remoteReset:
-		return 0, network.ErrReset
-	case <-p.readDeadline.wait():
-		return 0, os.ErrDeadlineExceeded
-	}
+	return nil
 }
 
 func (p *pipe) Write(b []byte) (int, error) {
+	defer p.block(&p.wrBlocked)()
+
 	n, err := p.write(b)
 	if err != nil && err != io.ErrClosedPipe {
 		err = &net.OpError{Op: "write", Net: "pipe", Err: err}
@@ -188,40 +243,250 @@ func (p *pipe) Write(b []byte) (int, error) {
 }
 
 func (p *pipe) write(b []byte) (n int, err error) {
-	switch {
-	case isClosedChan(p.localDone, p.remoteDone):
-		return 0, io.ErrClosedPipe
-	case isClosedChan(p.remoteReadDone, p.localReset, p.remoteReset):
-		return 0, network.ErrReset
-	case isClosedChan(p.writeDeadline.wait()):
-		return 0, os.ErrDeadlineExceeded
-	}
-
 	p.wrMu.Lock() // Ensure entirety of b is written together
 	defer p.wrMu.Unlock()
+	defer p.begin()()
+
+	if p.truncated {
+		return len(b), nil // discard data past the end of a truncated stream
+	}
+
+	if err = p.writeErr(); err != nil {
+		return 0, err
+	}
+
+	local, remote := p.peers()
+	proto := p.info.Protocol()
+
+	data, eof := p.faults.transform(local, remote, proto, p.written, b)
+	p.written += int64(len(b))
+
+	limit, rule := p.faults.write(local, remote, proto, p.info.Bytes(), len(data))
+	if limit >= 0 {
+		data = data[:limit]
+	}
+
+	if len(data) > 0 || len(b) == 0 {
+		if n, err = p.deliver(data); err == errDiscard {
+			var m int
+			m, err = p.discard(data[n:])
+			n += m
+		}
+
+		if err != nil {
+			return min(n, len(b)), err
+		}
+	}
+
+	if limit >= 0 {
+		p.inject(rule.Fault)
+		return min(n, len(b)), rule.err()
+	}
+
+	if eof {
+		p.truncated = true
+		p.closeWrite(false)
+	}
+
+	return len(b), nil
+}
+
+// errDiscard is returned by deliver when the remote end has closed
+// the stream for reading, and no longer consumes the data written to
+// it.
+var errDiscard = errors.New("remote closed for reading")
+
+// discardWindow is the amount of data that may be written after the
+// remote end has closed the stream for reading.  Yamux does not tell
+// the writer that the reader has gone, so its writes succeed until
+// they fill the initial 256 KiB receive window, and then block.
+const discardWindow = 256 << 10
+
+// deliver transfers b to the remote reader.
+func (p *pipe) deliver(b []byte) (n int, err error) {
 	for once := true; once || len(b) > 0; once = false {
+		if !p.sched.await(EventData, p.key, p.label,
+			p.localDone, p.localWriteDone, p.remoteDone, p.remoteReadDone,
+			p.localReset, p.remoteReset,
+			p.writeDeadline.wait()) {
+			return n, p.interrupted()
+		}
+
+		chunk := p.chunk.next(b)
+		if !p.traverse(len(chunk)) {
+			return n, p.interrupted()
+		}
+
+		// the chunk crosses the link once, even if the reader takes
+		// several reads to drain it
+		nw, err := p.transfer(chunk)
+		p.observe(b[:nw])
+		b = b[nw:]
+		n += nw
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// transfer hands the chunk to the remote reader, over as many reads
+// as it takes.
+func (p *pipe) transfer(chunk []byte) (n int, err error) {
+	for once := true; once || n < len(chunk); once = false {
 		select {
-		case p.wrTx <- b:
-			nw := <-p.wrRx
-			b = b[nw:]
-			n += nw
+		case p.wrTx <- chunk[n:]:
+			n += <-p.wrRx
+			continue
 		case <-p.localDone:
-			return n, io.ErrClosedPipe
+		case <-p.localWriteDone:
 		case <-p.remoteDone:
-			return n, io.ErrClosedPipe
+		case <-p.remoteReadDone:
 		case <-p.localReset:
-			return 0, network.ErrReset
 		case <-p.remoteReset:
-			return 0, network.ErrReset
-		case <-p.remoteReadDone:
-			return 0, network.ErrReset
 		case <-p.writeDeadline.wait():
-			return n, os.ErrDeadlineExceeded
 		}
+
+		return n, p.interrupted()
 	}
 	return n, nil
 }
 
+// discard accepts b on behalf of a remote reader that has closed the
+// stream for reading, until discardWindow is full.  Then, like a
+// yamux writer whose window is exhausted, it blocks until the write
+// fails.
+func (p *pipe) discard(b []byte) (int, error) {
+	n := min(len(b), discardWindow-p.discarded)
+	p.discarded += n
+	if n == len(b) {
+		return n, nil
+	}
+
+	select {
+	case <-p.localDone:
+	case <-p.localWriteDone:
+	case <-p.localReset:
+	case <-p.remoteReset:
+	case <-p.writeDeadline.wait():
+	}
+
+	if err := p.writeErr(); err != nil {
+		return n, err
+	}
+	return n, os.ErrDeadlineExceeded // the deadline passed, and has since been reset
+}
+
+// interrupted reports why a delivery was interrupted.
+func (p *pipe) interrupted() error {
+	if err := p.writeErr(); err != nil {
+		return err
+	}
+
+	if isClosedChan(p.remoteReadDone) {
+		return errDiscard
+	}
+
+	return os.ErrDeadlineExceeded // the deadline passed, and has since been reset
+}
+
+// traverse blocks for as long as it takes n bytes to cross the link
+// that carries the stream, including any time during which the link is
+// down.  It returns false if the write is interrupted.
+func (p *pipe) traverse(n int) bool {
+	if p.conn == nil {
+		return true
+	}
+
+	for {
+		link, changed := p.conn.link()
+		if link.Down {
+			if !p.await(changed) {
+				return false
+			}
+			continue
+		}
+
+		if d := p.conn.l.t.env.Links().delay(link, n); d > 0 {
+			return p.sleep(d)
+		}
+
+		return true
+	}
+}
+
+// sleep blocks for d, as measured by the writer's clock.  It returns
+// false if the write is interrupted.
+func (p *pipe) sleep(d time.Duration) bool {
+	done := make(chan struct{})
+	timer := p.writeDeadline.clock.AfterFunc(d, func() { close(done) })
+	defer timer.Stop()
+
+	return p.await(done)
+}
+
+// await blocks until ch is closed.  It returns false if the write is
+// interrupted first.
+func (p *pipe) await(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-p.localDone:
+	case <-p.localWriteDone:
+	case <-p.remoteDone:
+	case <-p.remoteReadDone:
+	case <-p.localReset:
+	case <-p.remoteReset:
+	case <-p.writeDeadline.wait():
+	}
+
+	return false
+}
+
+// observe records data that was delivered to the remote reader.
+func (p *pipe) observe(b []byte) {
+	p.info.delivered(len(b))
+	if p.sniff {
+		p.info.sniff(b)
+	}
+}
+
+// inject applies a stream fault on behalf of the FaultInjector.
+func (p *pipe) inject(fault Fault) {
+	if fault == FaultCloseConn && p.conn != nil {
+		p.conn.Close()
+		p.conn.remote.Close()
+	}
+
+	p.Reset()
+}
+
+func (p *pipe) peers() (local, remote peer.ID) {
+	if p.conn == nil {
+		return "", ""
+	}
+
+	return p.conn.LocalPeer(), p.conn.RemotePeer()
+}
+
+// writeErr reports why the pipe can no longer be written to, or nil
+// if it can.  As in yamux, a stream that was closed for writing fails
+// with ErrClosedPipe even if it was later reset.
+func (p *pipe) writeErr() error {
+	switch {
+	case isClosedChan(p.localWriteDone):
+		return io.ErrClosedPipe
+	case isClosedChan(p.localReset, p.remoteReset):
+		return network.ErrReset
+	case isClosedChan(p.localDone):
+		return io.ErrClosedPipe
+	case isClosedChan(p.writeDeadline.wait()):
+		return os.ErrDeadlineExceeded
+	}
+
+	return nil
+}
+
 func (p *pipe) SetDeadline(t time.Time) error {
 	if isClosedChan(p.localDone) || isClosedChan(p.remoteDone) {
 		return io.ErrClosedPipe
@@ -253,36 +518,141 @@ func (p *pipe) SetWriteDeadline(t time.Time) error {
 // * Future reads will fail.
 // * Any in-progress reads/writes will be interrupted.
 //
-// Close may be asynchronous and _does not_ guarantee receipt of the
-// data.
-func (p *pipe) Close() error {
-	p.once.Do(func() { close(p.localDone) })
-	return nil
+// Close is equivalent to CloseRead followed by CloseWrite.  The
+// remote reader sees EOF, and the remote writer is not told that the
+// stream was closed for reading.  Close returns ErrReset if the
+// stream was reset before it was closed for writing.
+func (p *pipe) Close() (err error) {
+	p.once.Do(func() {
+		defer p.tracker.removeStream(p)
+		defer p.conn.removeStream(p)
+		defer close(p.localDone)
+
+		if p.reset() {
+			if !isClosedChan(p.localWriteDone) {
+				err = network.ErrReset
+			}
+			return
+		}
+
+		p.sched.await(EventClose, p.key, p.label, p.localReset, p.remoteReset)
+		p.ronce.Do(func() { close(p.localReadDone) })
+		p.wonce.Do(func() {
+			p.flush()
+			close(p.localWriteDone)
+		})
+	})
+	return
 }
 
 // CloseWrite closes the stream for writing but leaves it open for
-// reading.
+// reading.  The remote reader sees EOF once it has consumed the data
+// that was written before CloseWrite.
 //
 // CloseWrite does not free the stream, users must still call Close or
 // Reset.
 func (p *pipe) CloseWrite() error {
-	p.wonce.Do(func() { close(p.localWriteDone) })
-	return nil
+	return p.closeWrite(true)
+}
+
+// closeWrite closes the stream for writing.  If flush is true, it
+// first waits for an in-progress write, as Close does.
+func (p *pipe) closeWrite(flush bool) (err error) {
+	p.wonce.Do(func() {
+		if p.reset() {
+			err = network.ErrReset
+			return
+		}
+
+		p.sched.await(EventClose, p.key, p.label, p.localReset, p.remoteReset)
+		if flush {
+			p.flush()
+		}
+		close(p.localWriteDone)
+	})
+	return
 }
 
-// CloseRead closes the stream for writing but leaves it open for
-// reading.
+// CloseRead closes the stream for reading but leaves it open for
+// writing.  In-progress reads are interrupted, and future reads fail
+// with ErrReset.  As with yamux, the remote writer is not told: its
+// writes are discarded until they would fill yamux's receive window,
+// and then block.
 //
 // CloseRead does not free the stream, users must still call Close or
 // Reset.
 func (p *pipe) CloseRead() error {
-	p.ronce.Do(func() { close(p.localReadDone) })
+	p.ronce.Do(func() {
+		p.sched.await(EventClose, p.key, p.label, p.localReset, p.remoteReset)
+		close(p.localReadDone)
+	})
 	return nil
 }
 
 // Reset closes both ends of the stream. Use this to tell the remote
-// side to hang up and go away.
+// side to hang up and go away.  Resetting a stream that has already
+// been closed in both directions only frees it.
 func (p *pipe) Reset() error {
-	p.resetOnce.Do(func() { close(p.localReset) })
+	p.resetOnce.Do(func() {
+		defer p.tracker.removeStream(p)
+		defer p.conn.removeStream(p)
+
+		if isClosedChan(p.localDone) ||
+			isClosedChan(p.localReadDone) && isClosedChan(p.localWriteDone) {
+			return
+		}
+
+		p.sched.await(EventReset, p.key, p.label, p.remoteReset)
+		close(p.localReset)
+	})
 	return nil
 }
+
+// reset returns true if either end of the stream has been reset.
+func (p *pipe) reset() bool {
+	return isClosedChan(p.localReset, p.remoteReset)
+}
+
+// begin records that a write is in progress, and returns a function
+// that records its end.  The caller must hold wrMu.
+func (p *pipe) begin() (end func()) {
+	writing := make(chan struct{})
+
+	p.flushMu.Lock()
+	p.writing = writing
+	p.flushMu.Unlock()
+
+	return func() {
+		p.flushMu.Lock()
+		p.writing = nil
+		p.flushMu.Unlock()
+
+		close(writing)
+	}
+}
+
+// flush waits for an in-progress write to be consumed by the remote
+// reader, for at most the pipe's linger time.  The write is
+// interrupted when the stream is closed, so data that is still in
+// flight after the linger time is lost.
+func (p *pipe) flush() {
+	if p.linger <= 0 {
+		return
+	}
+
+	p.flushMu.Lock()
+	writing := p.writing
+	p.flushMu.Unlock()
+
+	if writing == nil {
+		return
+	}
+
+	timer := p.writeDeadline.clock.Timer(p.linger)
+	defer timer.Stop()
+
+	select {
+	case <-writing:
+	case <-timer.C:
+	}
+}
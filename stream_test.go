@@ -0,0 +1,597 @@
+package inproc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadline(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	local, remote := newPipe(clk, clk)
+	defer local.Close()
+	defer remote.Close()
+
+	t.Run("Future", func(t *testing.T) {
+		require.NoError(t, local.SetReadDeadline(clk.Now().Add(time.Hour)))
+
+		cherr := make(chan error, 1)
+		go func() {
+			_, err := local.Read(make([]byte, 1))
+			cherr <- err
+		}()
+
+		clk.Add(time.Minute)
+		select {
+		case err := <-cherr:
+			t.Fatalf("read returned before deadline: %v", err)
+		default:
+		}
+
+		clk.Add(time.Hour)
+		require.ErrorIs(t, <-cherr, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("Past", func(t *testing.T) {
+		require.NoError(t, remote.SetWriteDeadline(clk.Now().Add(-time.Second)))
+
+		_, err := remote.Write([]byte("hello"))
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		require.NoError(t, remote.SetWriteDeadline(time.Time{}))
+		require.NoError(t, local.SetReadDeadline(time.Time{}))
+
+		go remote.Write([]byte("hello"))
+
+		n, err := local.Read(make([]byte, 5))
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+	})
+}
+
+func TestChunker(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Fixed", func(t *testing.T) {
+		t.Parallel()
+
+		local, remote := newPipe(clock.New(), clock.New())
+		local.chunk = FixedChunks(1)
+
+		go local.Write([]byte("hello"))
+
+		buf := make([]byte, 64)
+		for _, want := range "hello" {
+			n, err := remote.Read(buf)
+			require.NoError(t, err)
+			require.Equal(t, 1, n, "should deliver one byte per read")
+			require.Equal(t, byte(want), buf[0])
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		t.Parallel()
+
+		sizes := func(seed int64) (ns []int) {
+			local, remote := newPipe(clock.New(), clock.New())
+			local.chunk = RandomChunks(seed, 8)
+
+			go func() {
+				local.Write(make([]byte, 256))
+				local.CloseWrite()
+			}()
+
+			buf := make([]byte, 64)
+			for total := 0; total < 256; {
+				n, err := remote.Read(buf)
+				require.NoError(t, err)
+				require.LessOrEqual(t, n, 8)
+				ns = append(ns, n)
+				total += n
+			}
+			return
+		}
+
+		require.Equal(t, sizes(1), sizes(1))
+	})
+
+	t.Run("RandomMin", func(t *testing.T) {
+		t.Parallel()
+
+		for _, max := range []int{0, -1} {
+			require.NotPanics(t, func() {
+				require.Equal(t, 1, RandomChunks(1, max)(64))
+			}, "max %d should be treated as 1", max)
+		}
+	})
+}
+
+// TestHalfClose checks the semantics in the README's table of stream
+// semantics, which follow yamux.
+func TestHalfClose(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name                    string
+		close                   func(*pipe) error
+		localRead, localWrite   error
+		remoteRead, remoteWrite error
+	}{{
+		name:       "CloseWrite",
+		close:      (*pipe).CloseWrite,
+		localWrite: io.ErrClosedPipe,
+		remoteRead: io.EOF,
+	}, {
+		name:      "CloseRead",
+		close:     (*pipe).CloseRead,
+		localRead: network.ErrReset,
+	}, {
+		name:       "Close",
+		close:      (*pipe).Close,
+		localRead:  network.ErrReset,
+		localWrite: io.ErrClosedPipe,
+		remoteRead: io.EOF,
+	}, {
+		name:        "Reset",
+		close:       (*pipe).Reset,
+		localRead:   network.ErrReset,
+		localWrite:  network.ErrReset,
+		remoteRead:  network.ErrReset,
+		remoteWrite: network.ErrReset,
+	}, {
+		name: "CloseWriteThenReset",
+		close: func(p *pipe) error {
+			p.CloseWrite()
+			return p.Reset()
+		},
+		localRead:   network.ErrReset,
+		localWrite:  io.ErrClosedPipe,
+		remoteRead:  io.EOF,
+		remoteWrite: network.ErrReset,
+	}, {
+		name: "CloseThenReset",
+		close: func(p *pipe) error {
+			p.Close()
+			return p.Reset()
+		},
+		localRead:  network.ErrReset,
+		localWrite: io.ErrClosedPipe,
+		remoteRead: io.EOF,
+	}} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			local, remote := newPipe(clock.New(), clock.New())
+			require.NoError(t, tt.close(local))
+
+			checkRead(t, "local", local, remote, tt.localRead)
+			checkWrite(t, "local", local, remote, tt.localWrite)
+			checkRead(t, "remote", remote, local, tt.remoteRead)
+			checkWrite(t, "remote", remote, local, tt.remoteWrite)
+		})
+	}
+}
+
+// checkRead reads from p, while peer writes if the read is expected
+// to succeed.
+func checkRead(t *testing.T, end string, p, peer *pipe, want error) {
+	if want == nil {
+		go peer.Write([]byte("x"))
+	}
+
+	n, err := p.Read(make([]byte, 1))
+	if want == nil {
+		require.NoError(t, err, "%s read", end)
+		require.Equal(t, 1, n, "%s read", end)
+	} else {
+		require.ErrorIs(t, err, want, "%s read", end)
+	}
+}
+
+// checkWrite writes to p, while peer reads if the write is expected to
+// succeed.  Writes to a peer that closed for reading are discarded.
+func checkWrite(t *testing.T, end string, p, peer *pipe, want error) {
+	if want == nil {
+		go peer.Read(make([]byte, 1))
+	}
+
+	n, err := p.Write([]byte("x"))
+	if want == nil {
+		require.NoError(t, err, "%s write", end)
+		require.Equal(t, 1, n, "%s write", end)
+	} else {
+		require.ErrorIs(t, err, want, "%s write", end)
+	}
+}
+
+func TestCloseAfterReset(t *testing.T) {
+	t.Parallel()
+
+	local, remote := newPipe(clock.New(), clock.New())
+	require.NoError(t, local.CloseWrite())
+	require.NoError(t, remote.Reset())
+
+	require.ErrorIs(t, remote.CloseWrite(), network.ErrReset)
+	require.ErrorIs(t, remote.Close(), network.ErrReset)
+	require.NoError(t, local.Close(), "should not fail after CloseWrite")
+}
+
+func TestDiscardWindow(t *testing.T) {
+	t.Parallel()
+
+	local, remote := newPipe(clock.New(), clock.New())
+	require.NoError(t, remote.CloseRead())
+
+	n, err := local.Write(make([]byte, discardWindow))
+	require.NoError(t, err, "should discard writes up to the window")
+	require.Equal(t, discardWindow, n)
+
+	require.NoError(t, local.SetWriteDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = local.Write([]byte("x"))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded, "should block once the window is full")
+}
+
+func TestLinger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		_, dc, _, lc := newConnTest(t)
+		s, a := openTestStream(t, dc, lc)
+		for _, p := range []*pipe{s.(*pipe), a.(*pipe)} {
+			require.Positive(t, p.linger, "should linger by default")
+			require.Equal(t, DefaultLinger, p.linger)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		_, dc, _, lc := newConnTest(t, WithLinger(0))
+		s, _ := openTestStream(t, dc, lc)
+		local := s.(*pipe)
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := local.Write([]byte("hello")) // never read
+			written <- err
+		}()
+
+		require.Eventually(t, func() bool {
+			local.flushMu.Lock()
+			defer local.flushMu.Unlock()
+			return local.writing != nil
+		}, time.Second, time.Millisecond, "write should be in progress")
+
+		closed := make(chan error, 1)
+		go func() { closed <- local.Close() }()
+
+		select {
+		case err := <-closed:
+			require.NoError(t, err)
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("should not linger")
+		}
+		require.ErrorIs(t, <-written, io.ErrClosedPipe, "should interrupt write")
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		t.Parallel()
+
+		local, remote := newPipe(clock.New(), clock.New())
+		local.linger = time.Minute
+		local.chunk = FixedChunks(1)
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := local.Write([]byte("hello"))
+			written <- err
+		}()
+
+		buf := make([]byte, 1)
+		_, err := remote.Read(buf) // the write is now in progress
+		require.NoError(t, err)
+
+		closed := make(chan error, 1)
+		go func() { closed <- local.Close() }()
+
+		rest, err := io.ReadAll(remote)
+		require.NoError(t, err)
+		require.Equal(t, "ello", string(rest), "should flush before EOF")
+		require.NoError(t, <-written)
+		require.NoError(t, <-closed)
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		t.Parallel()
+
+		clk := clock.NewMock()
+		local, remote := newPipe(clk, clk)
+		local.linger = time.Minute
+		local.chunk = FixedChunks(1)
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := local.Write([]byte("hello"))
+			written <- err
+		}()
+
+		_, err := remote.Read(make([]byte, 1)) // the write is now in progress
+		require.NoError(t, err)
+
+		closed := make(chan error, 1)
+		go func() { closed <- local.Close() }()
+
+		require.Eventually(t, func() bool {
+			clk.Add(time.Minute)
+			return len(closed) > 0
+		}, time.Second, time.Millisecond, "should stop lingering")
+
+		require.NoError(t, <-closed)
+		require.ErrorIs(t, <-written, io.ErrClosedPipe, "should interrupt write")
+	})
+}
+
+func TestWriteAfterCloseWrite(t *testing.T) {
+	t.Parallel()
+
+	// A reader blocked on the remote end must not receive data written
+	// after CloseWrite, even though it is ready to take it.
+	for i := 0; i < 100; i++ {
+		local, remote := newPipe(clock.New(), clock.New())
+		remote.tracker = NewTracker() // records when the read blocks
+
+		read := make(chan error, 1)
+		go func() {
+			_, err := remote.Read(make([]byte, 1))
+			read <- err
+		}()
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&remote.rdBlocked) != 0
+		}, time.Second, time.Millisecond)
+
+		// fail rather than hang if a write waits for another reader
+		require.NoError(t, local.SetWriteDeadline(time.Now().Add(time.Second)))
+		require.NoError(t, local.CloseWrite())
+		_, err := local.Write([]byte("x"))
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		_, err = local.Write(nil)
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		require.ErrorIs(t, <-read, io.EOF, "should read EOF, not data written after CloseWrite")
+	}
+}
+
+// FuzzPipe drives random sequences of operations on both ends of a
+// pipe, and checks that data arrives intact and in order, that
+// operations after a local close fail, and that closing both ends
+// never leaves a read or write blocked.
+func FuzzPipe(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3})                 // read, write, close, ...
+	f.Add([]byte{1, 8, 4, 9, 0, 5, 11, 6, 7}) // writes, half-closes and resets
+	f.Add([]byte{0, 9, 7, 15, 1, 14, 3, 10})  // deadlines
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		local, remote := newPipe(clock.New(), clock.New())
+		ends := [2]*fuzzEnd{newFuzzEnd(t, local, len(ops)), newFuzzEnd(t, remote, len(ops))}
+		ends[0].peer, ends[1].peer = ends[1], ends[0]
+
+		for _, op := range ops {
+			e := ends[op&1]
+			switch op >> 1 % 8 {
+			case 0:
+				e.reads <- e.state()
+			case 1:
+				e.writes <- e.state()
+			case 2:
+				within(t, "Close", func() error {
+					return ignore(e.p.Close(), network.ErrReset)
+				})
+				e.closed = true
+			case 3:
+				within(t, "CloseRead", e.p.CloseRead)
+				e.readClosed = true
+			case 4:
+				within(t, "CloseWrite", func() error {
+					return ignore(e.p.CloseWrite(), network.ErrReset)
+				})
+				e.writeClosed = true
+			case 5:
+				within(t, "Reset", e.p.Reset)
+				e.reset = true
+			case 6:
+				within(t, "SetReadDeadline", func() error {
+					return ignore(e.p.SetReadDeadline(deadline(op)), io.ErrClosedPipe)
+				})
+			case 7:
+				within(t, "SetWriteDeadline", func() error {
+					return ignore(e.p.SetWriteDeadline(deadline(op)), io.ErrClosedPipe)
+				})
+			}
+		}
+
+		for _, e := range ends {
+			within(t, "Close", func() error {
+				return ignore(e.p.Close(), network.ErrReset)
+			})
+			close(e.reads)
+			close(e.writes)
+		}
+
+		for _, e := range ends {
+			select {
+			case <-e.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("read or write blocked after both ends closed")
+			}
+		}
+	})
+}
+
+// fuzzEnd queues reads and writes on one end of a pipe, so that they
+// may block without blocking the fuzzer.
+type fuzzEnd struct {
+	p    *pipe
+	peer *fuzzEnd
+
+	// set when the corresponding call returns
+	closed, readClosed, writeClosed, reset bool
+
+	reads, writes chan fuzzState
+	done          chan struct{}
+
+	written, read int64 // bytes written by and read from this end
+	eof           bool
+}
+
+// fuzzState is a snapshot of an end's state when an operation is
+// queued.
+type fuzzState struct {
+	closed, readClosed, writeClosed, reset bool
+}
+
+func newFuzzEnd(t *testing.T, p *pipe, ops int) *fuzzEnd {
+	e := &fuzzEnd{
+		p:      p,
+		reads:  make(chan fuzzState, ops),
+		writes: make(chan fuzzState, ops),
+		done:   make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for s := range e.reads {
+			e.doRead(t, s)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for s := range e.writes {
+			e.doWrite(t, s)
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(e.done)
+	}()
+
+	return e
+}
+
+func (e *fuzzEnd) state() fuzzState {
+	return fuzzState{
+		closed:      e.closed,
+		readClosed:  e.readClosed,
+		writeClosed: e.writeClosed,
+		reset:       e.reset,
+	}
+}
+
+func (e *fuzzEnd) doRead(t *testing.T, s fuzzState) {
+	buf := make([]byte, 7)
+	n, err := e.p.Read(buf)
+	checkPipeErr(t, "read", err)
+
+	if (s.closed || s.readClosed || s.reset) && (n > 0 || err == nil) {
+		t.Errorf("read %d bytes after local close", n)
+	}
+
+	if e.eof && n > 0 {
+		t.Errorf("read %d bytes after EOF", n)
+	}
+	e.eof = e.eof || errors.Is(err, io.EOF)
+
+	// data must match what the peer wrote, in order.  This runs on the
+	// end's reader goroutine, so it must not call t.Fatalf.
+	for _, b := range buf[:n] {
+		if want := pattern(e.read); b != want {
+			t.Errorf("read byte %d: got %d, want %d", e.read, b, want)
+			return
+		}
+		e.read++
+	}
+}
+
+func (e *fuzzEnd) doWrite(t *testing.T, s fuzzState) {
+	buf := make([]byte, 5)
+	for i := range buf {
+		buf[i] = pattern(e.written + int64(i))
+	}
+
+	n, err := e.p.Write(buf)
+	checkPipeErr(t, "write", err)
+
+	if (s.closed || s.writeClosed || s.reset) && (n > 0 || err == nil) {
+		t.Errorf("wrote %d bytes after local close", n)
+	}
+
+	if err == nil && n != len(buf) {
+		t.Errorf("short write of %d bytes without error", n)
+	}
+
+	e.written += int64(n)
+}
+
+func checkPipeErr(t *testing.T, op string, err error) {
+	switch {
+	case err == nil,
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrClosedPipe),
+		errors.Is(err, network.ErrReset),
+		errors.Is(err, os.ErrDeadlineExceeded):
+	default:
+		t.Errorf("%s: unexpected error: %v", op, err)
+	}
+}
+
+// pattern returns the byte at offset i of a stream, so that readers
+// can check what they receive without coordinating with writers.
+func pattern(i int64) byte { return byte(i % 251) }
+
+// deadline returns a deadline in the past, or no deadline, so that
+// the outcome does not depend on timing.
+func deadline(op byte) time.Time {
+	if op&0x80 != 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Second)
+}
+
+// ignore ignores an expected error, such as the one returned when
+// setting a deadline on a closed pipe, or closing a reset one.
+func ignore(err, target error) error {
+	if errors.Is(err, target) {
+		return nil
+	}
+	return err
+}
+
+// within fails the test if f blocks.
+func within(t *testing.T, name string, f func() error) {
+	cherr := make(chan error, 1)
+	go func() { cherr <- f() }()
+
+	select {
+	case err := <-cherr:
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s blocked", name)
+	}
+}
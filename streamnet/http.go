@@ -0,0 +1,33 @@
+package streamnet
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/mikelsr/go-libp2p/core/host"
+	"github.com/mikelsr/go-libp2p/core/protocol"
+)
+
+// ServeHTTP serves handler over streams for proto on h.  The caller
+// should stop the returned server with Close or Shutdown.
+func ServeHTTP(h host.Host, proto protocol.ID, handler http.Handler) *http.Server {
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(Listen(h, proto))
+
+	return srv
+}
+
+// NewRoundTripper returns an http.RoundTripper that sends requests
+// over streams for proto, opened by h.  The host part of each request
+// URL must be the ID of the target peer, e.g.
+// "http://12D3KooW.../path".
+func NewRoundTripper(h host.Host, proto protocol.ID) http.RoundTripper {
+	dial := Dialer(h, proto)
+
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, addr)
+		},
+	}
+}
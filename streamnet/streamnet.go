@@ -0,0 +1,168 @@
+// Package streamnet adapts libp2p streams to the net.Conn and
+// net.Listener interfaces, so that HTTP servers, gRPC services and
+// other plain Go networking code can communicate over libp2p hosts,
+// including hosts that use the inproc transport.
+//
+// gRPC is supported without a dependency on it:
+//
+//	go grpcServer.Serve(streamnet.Listen(h0, "/grpc"))
+//
+//	cc, err := grpc.Dial("passthrough:///"+h0.ID().String(),
+//		grpc.WithContextDialer(streamnet.Dialer(h1, "/grpc")),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))
+package streamnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/mikelsr/go-libp2p/core/host"
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/protocol"
+)
+
+// Network is the value returned by Addr.Network.
+const Network = "libp2p"
+
+var (
+	_ net.Conn     = (*Conn)(nil)
+	_ net.Listener = (*Listener)(nil)
+)
+
+// Addr is the net.Addr of a libp2p peer.
+type Addr struct{ ID peer.ID }
+
+func (Addr) Network() string  { return Network }
+func (a Addr) String() string { return a.ID.String() }
+
+// Conn is a net.Conn backed by a libp2p stream.
+//
+// Close closes the stream in both directions.  Use CloseWrite to
+// signal the end of a request while still reading the response, as
+// with *net.TCPConn.
+type Conn struct{ network.Stream }
+
+func (c Conn) LocalAddr() net.Addr  { return Addr{c.Stream.Conn().LocalPeer()} }
+func (c Conn) RemoteAddr() net.Addr { return Addr{c.Stream.Conn().RemotePeer()} }
+
+// handlers records the listener that handles each protocol on each
+// host, so that closing a listener that was replaced does not remove
+// the handler of the listener that replaced it.
+var handlers = struct {
+	sync.Mutex
+	m map[handlerKey]*Listener
+}{m: make(map[handlerKey]*Listener)}
+
+type handlerKey struct {
+	h     host.Host
+	proto protocol.ID
+}
+
+// Listener accepts streams for a protocol as net.Conns.
+type Listener struct {
+	h     host.Host
+	proto protocol.ID
+
+	once sync.Once
+	cq   chan struct{}
+	ch   chan network.Stream
+}
+
+// Listen handles streams for proto on h, and returns them as net.Conns
+// from Accept.  It replaces any existing handler for proto.  Closing
+// the listener removes its handler, unless a later call to Listen has
+// replaced it.
+func Listen(h host.Host, proto protocol.ID) *Listener {
+	l := &Listener{
+		h:     h,
+		proto: proto,
+		cq:    make(chan struct{}),
+		ch:    make(chan network.Stream),
+	}
+
+	handlers.Lock()
+	defer handlers.Unlock()
+
+	handlers.m[handlerKey{h, proto}] = l
+	h.SetStreamHandler(proto, func(s network.Stream) {
+		select {
+		case l.ch <- s:
+		case <-l.cq:
+			s.Reset()
+		}
+	})
+
+	return l
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.ch:
+		return Conn{s}, nil
+	case <-l.cq:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close removes the stream handler, if no later listener has replaced
+// it.  Streams that were already accepted are not affected.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		handlers.Lock()
+		defer handlers.Unlock()
+
+		key := handlerKey{l.h, l.proto}
+		if handlers.m[key] == l {
+			delete(handlers.m, key)
+			l.h.RemoveStreamHandler(l.proto)
+		}
+
+		close(l.cq)
+	})
+
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return Addr{l.h.ID()} }
+
+// Dial opens a stream for proto to the peer, and returns it as a
+// net.Conn.
+func Dial(ctx context.Context, h host.Host, id peer.ID, proto protocol.ID) (net.Conn, error) {
+	s, err := h.NewStream(ctx, id, proto)
+	if err != nil {
+		return nil, err
+	}
+
+	return Conn{s}, nil
+}
+
+// Dialer returns a dial function that opens streams for proto to the
+// peer whose ID is the host part of addr.  An optional port is
+// ignored.  The function is suitable for grpc.WithContextDialer.
+func Dialer(h host.Host, proto protocol.ID) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		id, err := parsePeer(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return Dial(ctx, h, id, proto)
+	}
+}
+
+func parsePeer(addr string) (peer.ID, error) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	id, err := peer.Decode(strings.TrimPrefix(addr, "/p2p/"))
+	if err != nil {
+		return "", fmt.Errorf("address %q is not a peer ID: %w", addr, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,143 @@
+package streamnet_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/go-libp2p-inproc-transport/inprocnet"
+	"github.com/mikelsr/go-libp2p-inproc-transport/streamnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	sim := inprocnet.New(t, 2)
+	require.NoError(t, sim.Connect(context.Background(), inprocnet.FullMesh))
+	h0, h1 := sim.Hosts[0], sim.Hosts[1]
+
+	l := streamnet.Listen(h0, "/echo")
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if assert.NoError(t, err) {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}
+	}()
+
+	dial := streamnet.Dialer(h1, "/echo")
+	conn, err := dial(context.Background(), h0.ID().String()+":80")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.Equal(t, h0.ID().String(), conn.RemoteAddr().String())
+	require.Equal(t, streamnet.Network, conn.LocalAddr().Network())
+
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+
+	// half-close, so that the server observes the end of the request
+	require.NoError(t, conn.(interface{ CloseWrite() error }).CloseWrite())
+
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+func TestListenReplaced(t *testing.T) {
+	t.Parallel()
+
+	sim := inprocnet.New(t, 2)
+	require.NoError(t, sim.Connect(context.Background(), inprocnet.FullMesh))
+	h0, h1 := sim.Hosts[0], sim.Hosts[1]
+
+	old := streamnet.Listen(h0, "/test")
+	l := streamnet.Listen(h0, "/test")
+	defer l.Close()
+
+	require.NoError(t, old.Close())
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, err := streamnet.Dial(context.Background(), h1, h0.ID(), "/test")
+	require.NoError(t, err, "should keep the handler of the replacing listener")
+	defer conn.Close()
+	require.NoError(t, <-accepted)
+}
+
+func TestHTTP(t *testing.T) {
+	t.Parallel()
+
+	sim := inprocnet.New(t, 2)
+	require.NoError(t, sim.Connect(context.Background(), inprocnet.FullMesh))
+	h0, h1 := sim.Hosts[0], sim.Hosts[1]
+
+	srv := streamnet.ServeHTTP(h0, "/http", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: streamnet.NewRoundTripper(h1, "/http")}
+	for _, path := range []string{"/foo", "/bar"} {
+		res, err := client.Get("http://" + h0.ID().String() + path)
+		require.NoError(t, err)
+
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, path, string(b))
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	t.Parallel()
+
+	sim := inprocnet.New(t, 2)
+	require.NoError(t, sim.Connect(context.Background(), inprocnet.FullMesh))
+	h0, h1 := sim.Hosts[0], sim.Hosts[1]
+
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(streamnet.Listen(h0, "/grpc"))
+	defer srv.Stop()
+
+	cc, err := grpc.Dial("passthrough:///"+h0.ID().String(),
+		grpc.WithContextDialer(streamnet.Dialer(h1, "/grpc")),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+}
+
+func TestDialer(t *testing.T) {
+	t.Parallel()
+
+	_, err := streamnet.Dialer(nil, "/test")(context.Background(), "not-a-peer")
+	require.Error(t, err)
+
+	var _ net.Addr = streamnet.Addr{}
+}
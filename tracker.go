@@ -0,0 +1,165 @@
+package inproc
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mikelsr/go-libp2p/core/protocol"
+)
+
+// Tracker records the conns and streams that are open in an Env, with
+// the stacks that opened them, so that tests can report leaks.  A nil
+// *Tracker records nothing.
+type Tracker struct {
+	mu      sync.Mutex
+	conns   map[*conn][]byte
+	streams map[*pipe][]byte
+}
+
+// Trace describes a conn or stream that is open at one of its ends.
+type Trace struct {
+	Label    string
+	Protocol protocol.ID   // empty for conns, and for streams until negotiated
+	Stack    string        // of the call that opened the conn or stream
+	Blocked  time.Duration // how long a read or write has been blocked
+}
+
+// NewTracker returns a tracker for use with WithTracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		conns:   make(map[*conn][]byte),
+		streams: make(map[*pipe][]byte),
+	}
+}
+
+// Conns returns the conns that are open, ordered by label.
+func (tr *Tracker) Conns() []Trace {
+	if tr == nil {
+		return nil
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	ts := make([]Trace, 0, len(tr.conns))
+	for c, stack := range tr.conns {
+		ts = append(ts, Trace{
+			Label: fmt.Sprintf("%s->%s", c.LocalMultiaddr(), c.RemoteMultiaddr()),
+			Stack: string(stack),
+		})
+	}
+
+	return sortTraces(ts)
+}
+
+// Streams returns the streams that are open, ordered by label.
+func (tr *Tracker) Streams() []Trace {
+	if tr == nil {
+		return nil
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	ts := make([]Trace, 0, len(tr.streams))
+	for p, stack := range tr.streams {
+		ts = append(ts, Trace{
+			Label:    p.label,
+			Protocol: p.info.Protocol(),
+			Stack:    string(stack),
+			Blocked:  p.blocked(),
+		})
+	}
+
+	return sortTraces(ts)
+}
+
+func sortTraces(ts []Trace) []Trace {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Label < ts[j].Label })
+	return ts
+}
+
+func (tr *Tracker) addConn(cs ...*conn) {
+	if tr == nil {
+		return
+	}
+
+	stack := debug.Stack()
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for _, c := range cs {
+		tr.conns[c] = stack
+	}
+}
+
+func (tr *Tracker) removeConn(c *conn) {
+	if tr == nil {
+		return
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	delete(tr.conns, c)
+}
+
+// addStream records both ends of a new stream.
+func (tr *Tracker) addStream(ps ...*pipe) {
+	if tr == nil {
+		return
+	}
+
+	stack := debug.Stack()
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for _, p := range ps {
+		p.tracker = tr
+		tr.streams[p] = stack
+	}
+}
+
+func (tr *Tracker) removeStream(p *pipe) {
+	if tr == nil {
+		return
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	delete(tr.streams, p)
+}
+
+// block records that a read or write may block, until the returned
+// function is called.  It is a no-op for untracked pipes.
+func (p *pipe) block(since *int64) func() {
+	if p.tracker == nil {
+		return func() {}
+	}
+
+	atomic.StoreInt64(since, p.readDeadline.clock.Now().UnixNano())
+	return func() { atomic.StoreInt64(since, 0) }
+}
+
+// blocked returns how long the pipe's current read or write, whichever
+// started first, has been blocked.
+func (p *pipe) blocked() (d time.Duration) {
+	now := p.readDeadline.clock.Now().UnixNano()
+	for _, since := range []int64{
+		atomic.LoadInt64(&p.rdBlocked),
+		atomic.LoadInt64(&p.wrBlocked),
+	} {
+		if since != 0 && time.Duration(now-since) > d {
+			d = time.Duration(now - since)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,53 @@
+package inproc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+
+	tr := inproc.NewTracker()
+	env := inproc.NewEnv(inproc.WithTracker(tr))
+	ma := multiaddr.StringCast("/inproc/tracked")
+
+	l, err := inproc.Listen(env, ma)
+	require.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_, err = conn.Read(make([]byte, 1)) // blocks until closed
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, err := inproc.Dial(context.Background(), env, ma)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		for _, trace := range tr.Streams() {
+			if trace.Blocked > 0 {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond, "should report blocked read")
+
+	streams := tr.Streams()
+	require.Len(t, streams, 2, "should track both ends")
+	require.Contains(t, streams[0].Stack, "tracker_test.go")
+
+	require.NoError(t, conn.Close())
+	<-accepted
+	require.Empty(t, tr.Streams(), "should forget closed streams")
+}
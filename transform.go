@@ -0,0 +1,53 @@
+package inproc
+
+import "math/rand"
+
+// Transformer rewrites a chunk of data written to a stream, before it
+// is delivered to the remote reader.  It is passed the offset of the
+// chunk within the writer's half of the stream, and a seeded PRNG.  It
+// returns the data to deliver in place of the chunk, and whether the
+// writer's half of the stream ends after it.
+//
+// The writer is always told that the entire chunk was written, so
+// transformers are suitable for simulating a misbehaving network or
+// peer.  Transformers must not modify the chunk in place.
+type Transformer func(offset int64, chunk []byte, rand *rand.Rand) (out []byte, eof bool)
+
+// FlipBit flips a single random bit in each chunk.
+func FlipBit(_ int64, chunk []byte, rand *rand.Rand) ([]byte, bool) {
+	if len(chunk) == 0 {
+		return chunk, false
+	}
+
+	out := append([]byte(nil), chunk...)
+	i := rand.Intn(len(out) * 8)
+	out[i/8] ^= 1 << (i % 8)
+
+	return out, false
+}
+
+// DropChunk discards each chunk.
+func DropChunk(int64, []byte, *rand.Rand) ([]byte, bool) { return nil, false }
+
+// DuplicateChunk delivers each chunk twice.
+func DuplicateChunk(_ int64, chunk []byte, _ *rand.Rand) ([]byte, bool) {
+	out := make([]byte, 0, 2*len(chunk))
+	return append(append(out, chunk...), chunk...), false
+}
+
+// TruncateAt returns a transformer that ends the stream after the
+// first n bytes.  Subsequent data is silently discarded, and the
+// remote reader receives io.EOF.
+func TruncateAt(n int64) Transformer {
+	return func(offset int64, chunk []byte, _ *rand.Rand) ([]byte, bool) {
+		if offset+int64(len(chunk)) < n {
+			return chunk, false
+		}
+
+		if offset >= n {
+			return nil, true
+		}
+
+		return chunk[:n-offset], true
+	}
+}
@@ -0,0 +1,93 @@
+package inproc
+
+import (
+	"io"
+	"math/bits"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	t.Parallel()
+
+	t.Run("TruncateAt", func(t *testing.T) {
+		t.Parallel()
+
+		local, remote := newTransformPipe(TruncateAt(3))
+		go func() {
+			for _, s := range []string{"he", "llo", "world"} {
+				n, err := local.Write([]byte(s))
+				assert.NoError(t, err)
+				assert.Equal(t, len(s), n, "writer should not observe truncation")
+			}
+		}()
+
+		b, err := io.ReadAll(remote)
+		require.NoError(t, err)
+		require.Equal(t, "hel", string(b))
+	})
+
+	t.Run("DuplicateChunk", func(t *testing.T) {
+		t.Parallel()
+
+		local, remote := newTransformPipe(DuplicateChunk)
+		go func() {
+			local.Write([]byte("ab"))
+			local.CloseWrite()
+		}()
+
+		b, err := io.ReadAll(remote)
+		require.NoError(t, err)
+		require.Equal(t, "abab", string(b))
+	})
+
+	t.Run("DropChunk", func(t *testing.T) {
+		t.Parallel()
+
+		local, remote := newTransformPipe(DropChunk)
+		go func() {
+			local.Write([]byte("dropped"))
+			local.faults.Clear()
+			local.Write([]byte("kept"))
+			local.CloseWrite()
+		}()
+
+		b, err := io.ReadAll(remote)
+		require.NoError(t, err)
+		require.Equal(t, "kept", string(b))
+	})
+
+	t.Run("FlipBit", func(t *testing.T) {
+		t.Parallel()
+
+		in := []byte("hello, world!")
+		local, remote := newTransformPipe(FlipBit)
+		go func() {
+			local.Write(in)
+			local.CloseWrite()
+		}()
+
+		out, err := io.ReadAll(remote)
+		require.NoError(t, err)
+		require.Len(t, out, len(in))
+		require.Equal(t, "hello, world!", string(in), "should not modify writer's buffer")
+
+		var flipped int
+		for i := range in {
+			flipped += bits.OnesCount8(in[i] ^ out[i])
+		}
+		require.Equal(t, 1, flipped)
+	})
+}
+
+func newTransformPipe(tf Transformer) (*pipe, *pipe) {
+	f := NewFaultInjector(0)
+	f.Inject(Rule{Fault: FaultTransform, Transform: tf})
+
+	local, remote := newPipe(clock.New(), clock.New())
+	local.faults, remote.faults = f, f
+	return local, remote
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/mikelsr/go-libp2p/core/crypto"
 	"github.com/mikelsr/go-libp2p/core/host"
 	"github.com/mikelsr/go-libp2p/core/peer"
@@ -22,30 +25,87 @@ var (
 	// ErrRefused is returned when dialing an address on which a peer is
 	// not accepting connections.
 	ErrRefused = errors.New("connection refused")
+
+	// ErrStreamsExhausted is returned when opening a stream on a conn
+	// whose remote end has reached its stream limit, or whose accept
+	// backlog is full.
+	ErrStreamsExhausted = errors.New("streams exhausted")
+
+	// ErrConnClosed is returned when opening a stream on a conn that
+	// is closed at either end.
+	ErrConnClosed = errors.New("conn closed")
+
+	// ErrStreamRefused is returned when opening a stream that the
+	// remote end of the conn refuses.  See WithStreamFilter.
+	ErrStreamRefused = errors.New("stream refused")
 )
 
 // Transport for fast in-process communication.
 type Transport struct {
-	env Env
+	env    ExtendedEnv
+	clock  clock.Clock
+	chunk  Chunker
+	linger time.Duration
+
+	maxStreams int // inbound streams per conn; unlimited if zero
+	backlog    int // streams per conn waiting to be accepted; unlimited if zero
+
+	filter func(transport.CapableConn) bool // refuses inbound streams; nil accepts all
+
+	idleTimeout time.Duration // zero if idle conns are kept open
+	keepAlive   time.Duration // zero if keepalives are disabled
+
+	src   uint64 // orders the transport's events; see Scheduler
+	dials uint64 // atomic; conns dialed by the transport
 
 	h  host.Host
 	pk crypto.PrivKey
 
-	mu sync.RWMutex
-	ls map[string]*listener
+	mu    sync.RWMutex
+	ls    map[string]*listener
+	nls   map[string]*netListener
+	conns []tracked          // accepted conns, for load balancing
+	cs    map[*conn]struct{} // open conns, for snapshots
+
+	// forward dials the real network on behalf of the transport that
+	// dials this one.  It is set for addresses imported by a Gateway.
+	forward func(ctx context.Context, dialer *Transport, p peer.ID) (transport.CapableConn, error)
 }
 
 // Dial dials a remote peer. It should try to reuse local listener
 // addresses if possible but it may choose not to.
-func (t *Transport) Dial(ctx context.Context, raddr multiaddr.Multiaddr, _ peer.ID) (transport.CapableConn, error) {
-	t.env.Lock() // accept may need to bind a dialback listener
-	defer t.env.Unlock()
+func (t *Transport) Dial(ctx context.Context, raddr multiaddr.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	t.env.Lock()
+	bound, ok := t.env.Lookup(raddr)
+	if !ok {
+		t.env.Unlock()
+		return nil, ErrRefused
+	}
+
+	if bound.forward != nil {
+		t.env.Unlock() // forwarded dials leave the Env, and may be slow
+		if err := t.env.Faults().dial(t.id(), p); err != nil {
+			return nil, err
+		}
+
+		return bound.forward(ctx, t, p)
+	}
 
-	if bound, ok := t.env.Lookup(raddr); ok {
-		return bound.accept(ctx, raddr, t)
+	if err := t.env.Faults().dial(t.id(), bound.id()); err != nil {
+		t.env.Unlock()
+		return nil, err
 	}
 
-	return nil, ErrRefused
+	// Bind the dialback listener under the lock, but release it before
+	// waiting for the listener to accept, so that a slow accept does
+	// not block the rest of the Env.
+	d, err := t.dialback()
+	t.env.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return bound.accept(ctx, raddr, d)
 }
 
 // CanDial returns true if this transport knows how to dial the given
@@ -58,18 +118,29 @@ func (t *Transport) CanDial(addr multiaddr.Multiaddr) bool {
 	return addr.Protocols()[0].Code == P_INPROC
 }
 
-// Listen listens on the passed multiaddr.
+// Listen listens on the passed multiaddr.  The multiaddr may be a
+// pattern; see IsPattern.
 func (t *Transport) Listen(laddr multiaddr.Multiaddr) (transport.Listener, error) {
 	laddr, err := Resolve(laddr)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = validatePattern(laddr); err != nil {
+		return nil, err
+	}
+
 	t.env.Lock()
 	defer t.env.Unlock()
 
 	if t.env.Bind(laddr, t) {
-		return t.newListener(laddr)
+		l, err := t.newListener(laddr)
+		if err != nil {
+			return nil, err
+		}
+
+		t.renew(laddr, l.cq, l.Close)
+		return l, nil
 	}
 
 	return nil, ErrInUse
@@ -100,9 +171,35 @@ func (t *Transport) newListener(laddr multiaddr.Multiaddr) (*listener, error) {
 	return l, nil
 }
 
-func (t *Transport) accept(ctx context.Context, raddr multiaddr.Multiaddr, dialer *Transport) (*conn, error) {
+func (t *Transport) accept(ctx context.Context, raddr multiaddr.Multiaddr, d *listener) (*conn, error) {
 	t.mu.RLock()
-	defer t.mu.RUnlock()
+	l, ok := lookupAddr(t.ls, raddr)
+	t.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrRefused // bound by a net.Listener
+	}
+
+	return l.NewConn(ctx, raddr, d)
+}
+
+// id returns the ID of the transport's host or, if the transport was
+// created without one, the ID derived from its private key.
+func (t *Transport) id() peer.ID {
+	if t.h != nil {
+		return t.h.ID()
+	}
+
+	if t.pk != nil {
+		id, _ := peer.IDFromPrivateKey(t.pk)
+		return id
+	}
+
+	return ""
+}
 
-	return t.ls[raddr.String()].NewConn(ctx, dialer)
+// nextSource returns the scheduler source of the next conn that the
+// transport dials.
+func (t *Transport) nextSource() source {
+	return source{t.src, atomic.AddUint64(&t.dials, 1)}
 }
@@ -2,12 +2,15 @@ package inproc_test
 
 import (
 	"context"
+	"io"
 	"testing"
 
 	"github.com/mikelsr/go-libp2p"
 	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
 	"github.com/mikelsr/go-libp2p/core/host"
 	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -75,3 +78,124 @@ func newTestHost(env inproc.Env) (host.Host, error) {
 		libp2p.Transport(inproc.New(inproc.WithEnv(env))),
 		libp2p.ListenAddrStrings("/inproc/~")) // auto-bind
 }
+
+func TestFaults(t *testing.T) {
+	t.Parallel()
+
+	f := inproc.NewFaultInjector(0)
+	env := inproc.NewEnv(inproc.WithFaults(f))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h0, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h0.Close()
+
+	h1, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	t.Run("Dial", func(t *testing.T) {
+		h2, err := newTestHost(env)
+		require.NoError(t, err)
+		defer h2.Close()
+
+		remove := f.Inject(inproc.Rule{Fault: inproc.FaultDial, Local: h2.ID()})
+		defer remove()
+
+		err = h2.Connect(ctx, *host.InfoFromHost(h0))
+		require.ErrorContains(t, err, inproc.ErrInjected.Error())
+	})
+
+	t.Run("ResetStream", func(t *testing.T) {
+		f.Inject(inproc.Rule{
+			Fault:      inproc.FaultResetStream,
+			Protocol:   "/test/fault",
+			AfterBytes: 1024,
+		})
+
+		h0.SetStreamHandler("/test/fault", func(s network.Stream) {
+			defer s.Close()
+			s.Write([]byte("ok"))
+			io.Copy(io.Discard, s)
+		})
+
+		err := h1.Connect(ctx, *host.InfoFromHost(h0))
+		require.NoError(t, err)
+
+		s, err := h1.NewStream(ctx, h0.ID(), "/test/fault")
+		require.NoError(t, err)
+		defer s.Close()
+
+		// wait for the responder to accept the protocol
+		_, err = io.ReadFull(s, make([]byte, 2))
+		require.NoError(t, err)
+
+		_, err = s.Write(make([]byte, 2048))
+		require.ErrorIs(t, err, inproc.ErrInjected)
+	})
+}
+
+func TestPatternListen(t *testing.T) {
+	t.Parallel()
+
+	env := inproc.NewEnv()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h0, err := libp2p.New(
+		libp2p.NoTransports,
+		libp2p.Transport(inproc.New(inproc.WithEnv(env))),
+		libp2p.ListenAddrStrings("/inproc/service-*"))
+	require.NoError(t, err)
+	defer h0.Close()
+
+	h1, err := newTestHost(env)
+	require.NoError(t, err)
+	defer h1.Close()
+
+	ma := multiaddr.StringCast("/inproc/service-1")
+	err = h1.Connect(ctx, peer.AddrInfo{ID: h0.ID(), Addrs: []multiaddr.Multiaddr{ma}})
+	require.NoError(t, err)
+
+	conns := h0.Network().ConnsToPeer(h1.ID())
+	require.Len(t, conns, 1)
+	require.True(t, ma.Equal(conns[0].LocalMultiaddr()),
+		"should report dialed address, not pattern")
+}
+
+func TestCloseListener(t *testing.T) {
+	t.Parallel()
+
+	env := inproc.NewEnv()
+	lt := inproc.New(inproc.WithEnv(env))(nil, nil)
+	dt := inproc.New(inproc.WithEnv(env))(nil, nil)
+
+	l, err := lt.Listen(multiaddr.StringCast("/inproc/~"))
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	closed, err := dt.Listen(multiaddr.StringCast("/inproc/~"))
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+	require.NoError(t, closed.Close(), "should close idempotently")
+
+	c, err := dt.Dial(context.Background(), l.Multiaddr(), "")
+	require.NoError(t, err)
+	defer c.Close()
+	require.False(t, closed.Multiaddr().Equal(c.LocalMultiaddr()),
+		"should not dial back from a closed listener")
+
+	for _, p := range env.Snapshot().Peers {
+		require.Equal(t, 1, p.Listeners, "should not count closed listeners")
+	}
+}
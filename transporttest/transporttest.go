@@ -0,0 +1,406 @@
+// Package transporttest is a conformance suite for libp2p transports
+// that provide their own stream multiplexing, such as the inproc
+// transport.  It is modelled on go-libp2p's transport and muxer test
+// harnesses, and may be run against any transport that returns
+// capable conns.
+package transporttest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikelsr/go-libp2p/core/network"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/transport"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Pair of transports under test.
+type Pair struct {
+	// Listener listens on Addr, and is identified by Peer.
+	Listener transport.Transport
+	Addr     multiaddr.Multiaddr
+	Peer     peer.ID
+
+	// Dialer dials the Listener.
+	Dialer transport.Transport
+}
+
+// Setup returns a fresh pair of transports for each subtest.
+type Setup func(t *testing.T) Pair
+
+// Run the conformance suite.
+func Run(t *testing.T, setup Setup) {
+	for _, tt := range []struct {
+		name string
+		test func(*testing.T, Pair)
+	}{
+		{"Echo", SubtestEcho},
+		{"ConcurrentStreams", SubtestConcurrentStreams},
+		{"LargeTransfer", SubtestLargeTransfer},
+		{"HalfCloseDialer", SubtestHalfCloseDialer},
+		{"HalfCloseListener", SubtestHalfCloseListener},
+		{"ReadDeadline", SubtestReadDeadline},
+		{"WriteDeadline", SubtestWriteDeadline},
+		{"ResetDuringRead", SubtestResetDuringRead},
+		{"ResetDuringWrite", SubtestResetDuringWrite},
+		{"ConnCloseDuringIO", SubtestConnCloseDuringIO},
+		{"ListenerCloseDuringAccept", SubtestListenerCloseDuringAccept},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tt.test(t, setup(t))
+		})
+	}
+}
+
+// SubtestEcho opens a stream and echoes a message over it.
+func SubtestEcho(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	go serve(lc, echo)
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+// SubtestConcurrentStreams echoes data over many streams at once.
+func SubtestConcurrentStreams(t *testing.T, p Pair) {
+	const streams, size = 100, 4 << 10
+
+	dc, lc := connect(t, p)
+	go serve(lc, echo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		in := random(t, size) // require must not be called from the goroutines
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s, err := dc.OpenStream(context.Background())
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer s.Close()
+
+			go func() {
+				s.Write(in)
+				s.CloseWrite()
+			}()
+
+			out, err := io.ReadAll(s)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(in, out), "stream corrupted data")
+		}()
+	}
+	wg.Wait()
+}
+
+// SubtestLargeTransfer sends several megabytes over a single stream.
+func SubtestLargeTransfer(t *testing.T, p Pair) {
+	const size = 8 << 20
+
+	dc, lc := connect(t, p)
+	go serve(lc, echo)
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	in := random(t, size)
+	go func() {
+		s.Write(in)
+		s.CloseWrite()
+	}()
+
+	out, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, len(in), len(out))
+	require.True(t, bytes.Equal(in, out), "stream corrupted data")
+}
+
+// SubtestHalfCloseDialer checks that the listener can still write
+// after the dialer closes its side of a stream.
+func SubtestHalfCloseDialer(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	go serve(lc, func(s network.MuxedStream) {
+		b, err := io.ReadAll(s) // until the dialer closes for writing
+		if assert.NoError(t, err) {
+			_, err = s.Write(b)
+			assert.NoError(t, err, "should write after remote CloseWrite")
+		}
+		s.Close()
+	})
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+}
+
+// SubtestHalfCloseListener checks that the dialer can still write
+// after the listener closes its side of a stream.
+func SubtestHalfCloseListener(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	received := make(chan []byte, 1)
+	go serve(lc, func(s network.MuxedStream) {
+		defer s.Close()
+
+		_, err := s.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, s.CloseWrite())
+
+		b, err := io.ReadAll(s)
+		assert.NoError(t, err, "should read after local CloseWrite")
+		received <- b
+	})
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Some muxers announce a stream on its first write.  The write may
+	// block until the listener reads, if the stream is unbuffered.
+	pinged := make(chan error, 1)
+	go func() {
+		_, err := s.Write([]byte("ping"))
+		pinged <- err
+	}()
+
+	b, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	require.NoError(t, <-pinged)
+
+	_, err = s.Write([]byte("pong"))
+	require.NoError(t, err, "should write after remote CloseWrite")
+	require.NoError(t, s.CloseWrite())
+	require.Equal(t, "pingpong", string(<-received))
+}
+
+// SubtestReadDeadline checks that a blocked read times out.
+func SubtestReadDeadline(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	go serve(lc, idle)
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = s.Read(make([]byte, 1))
+	require.True(t, isTimeout(err), "should time out, got %v", err)
+}
+
+// SubtestWriteDeadline checks that a write times out when the remote
+// end does not read.
+func SubtestWriteDeadline(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	go serve(lc, idle)
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	// A muxer may buffer writes until its window fills, so keep
+	// writing until the deadline passes.
+	require.NoError(t, s.SetWriteDeadline(time.Now().Add(50*time.Millisecond)))
+	buf := make([]byte, 64<<10)
+	for i := 0; i < 1024; i++ {
+		if _, err = s.Write(buf); err != nil {
+			break
+		}
+	}
+	require.True(t, isTimeout(err), "should time out, got %v", err)
+}
+
+// SubtestResetDuringRead checks that a remote reset interrupts a
+// blocked read.
+func SubtestResetDuringRead(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	reading := make(chan struct{})
+	go serve(lc, func(s network.MuxedStream) {
+		s.Read(make([]byte, 1)) // wait for the stream to be opened
+		<-reading
+		s.Reset()
+	})
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Write([]byte("x"))
+	require.NoError(t, err)
+
+	close(reading)
+	_, err = s.Read(make([]byte, 1))
+	require.Error(t, err, "read should fail after remote reset")
+}
+
+// SubtestResetDuringWrite checks that a remote reset interrupts a
+// blocked write.
+func SubtestResetDuringWrite(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	go serve(lc, func(s network.MuxedStream) {
+		s.Read(make([]byte, 1)) // wait for the dialer to start writing
+		s.Reset()
+	})
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.Eventually(t, func() bool {
+		_, err = s.Write(make([]byte, 64<<10))
+		return err != nil
+	}, 5*time.Second, time.Millisecond, "write should fail after remote reset")
+}
+
+// SubtestConnCloseDuringIO checks that closing a conn interrupts the
+// streams that are blocked on it, at both ends.
+func SubtestConnCloseDuringIO(t *testing.T, p Pair) {
+	dc, lc := connect(t, p)
+	accepted := make(chan struct{})
+	remote := make(chan error, 1)
+	go serve(lc, func(s network.MuxedStream) {
+		s.Read(make([]byte, 1)) // wait for the stream to be opened
+		close(accepted)
+		_, err := io.Copy(io.Discard, s)
+		remote <- err
+	})
+
+	s, err := dc.OpenStream(context.Background())
+	require.NoError(t, err)
+
+	_, err = s.Write([]byte("x"))
+	require.NoError(t, err)
+
+	local := make(chan error, 1)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		local <- err
+	}()
+
+	<-accepted
+	require.NoError(t, dc.Close())
+
+	require.Error(t, <-local, "local read should fail after conn close")
+	require.Error(t, <-remote, "remote read should fail after conn close")
+}
+
+// SubtestListenerCloseDuringAccept checks that closing a listener
+// interrupts a blocked accept.
+func SubtestListenerCloseDuringAccept(t *testing.T, p Pair) {
+	l, err := p.Listener.Listen(p.Addr)
+	require.NoError(t, err)
+
+	accepting := make(chan struct{})
+	cherr := make(chan error, 1)
+	go func() {
+		close(accepting)
+		_, err := l.Accept()
+		cherr <- err
+	}()
+
+	<-accepting
+	require.NoError(t, l.Close())
+
+	select {
+	case err := <-cherr:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept should return after listener close")
+	}
+}
+
+// connect returns both ends of a conn from the dialer to the listener.
+// They are closed when the test completes.
+func connect(t *testing.T, p Pair) (dialed, accepted transport.CapableConn) {
+	t.Helper()
+
+	l, err := p.Listener.Listen(p.Addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	type result struct {
+		c   transport.CapableConn
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := l.Accept()
+		ch <- result{c, err}
+	}()
+
+	dialed, err = p.Dialer.Dial(context.Background(), l.Multiaddr(), p.Peer)
+	require.NoError(t, err)
+	t.Cleanup(func() { dialed.Close() })
+
+	res := <-ch
+	require.NoError(t, res.err)
+	t.Cleanup(func() { res.c.Close() })
+
+	return dialed, res.c
+}
+
+// serve handles each stream that is accepted by c, until c is closed.
+func serve(c transport.CapableConn, handle func(network.MuxedStream)) {
+	for {
+		s, err := c.AcceptStream()
+		if err != nil {
+			return
+		}
+		go handle(s)
+	}
+}
+
+func echo(s network.MuxedStream) {
+	defer s.Close()
+
+	io.Copy(s, s)
+	s.CloseWrite()
+}
+
+// idle accepts the stream, but never reads from or writes to it.
+func idle(s network.MuxedStream) {}
+
+// isTimeout reports whether err is a timeout.  Muxers do not all
+// return os.ErrDeadlineExceeded.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func random(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
@@ -0,0 +1,77 @@
+package transporttest_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	inproc "github.com/mikelsr/go-libp2p-inproc-transport"
+	"github.com/mikelsr/go-libp2p-inproc-transport/transporttest"
+	"github.com/mikelsr/go-libp2p/core/crypto"
+	"github.com/mikelsr/go-libp2p/core/peer"
+	"github.com/mikelsr/go-libp2p/core/sec"
+	"github.com/mikelsr/go-libp2p/p2p/muxer/yamux"
+	"github.com/mikelsr/go-libp2p/p2p/net/upgrader"
+	"github.com/mikelsr/go-libp2p/p2p/security/noise"
+	"github.com/mikelsr/go-libp2p/p2p/transport/tcp"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInproc(t *testing.T) {
+	t.Parallel()
+
+	transporttest.Run(t, func(t *testing.T) transporttest.Pair {
+		env := inproc.NewEnv()
+		lk, lid := newIdentity(t)
+		dk, _ := newIdentity(t)
+
+		return transporttest.Pair{
+			Listener: inproc.New(inproc.WithEnv(env))(nil, lk),
+			Addr:     multiaddr.StringCast("/inproc/~"),
+			Peer:     lid,
+			Dialer:   inproc.New(inproc.WithEnv(env))(nil, dk),
+		}
+	})
+}
+
+// TestTCP checks the suite itself against a reference transport.
+func TestTCP(t *testing.T) {
+	t.Parallel()
+
+	transporttest.Run(t, func(t *testing.T) transporttest.Pair {
+		lk, lid := newIdentity(t)
+		dk, _ := newIdentity(t)
+
+		return transporttest.Pair{
+			Listener: newTCP(t, lk),
+			Addr:     multiaddr.StringCast("/ip4/127.0.0.1/tcp/0"),
+			Peer:     lid,
+			Dialer:   newTCP(t, dk),
+		}
+	})
+}
+
+func newIdentity(t *testing.T) (crypto.PrivKey, peer.ID) {
+	pk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	id, err := peer.IDFromPrivateKey(pk)
+	require.NoError(t, err)
+
+	return pk, id
+}
+
+func newTCP(t *testing.T, pk crypto.PrivKey) *tcp.TcpTransport {
+	muxers := []upgrader.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}
+
+	security, err := noise.New(noise.ID, pk, muxers)
+	require.NoError(t, err)
+
+	u, err := upgrader.New([]sec.SecureTransport{security}, muxers, nil, nil, nil)
+	require.NoError(t, err)
+
+	tpt, err := tcp.NewTCPTransport(u, nil)
+	require.NoError(t, err)
+
+	return tpt
+}